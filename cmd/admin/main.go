@@ -0,0 +1,476 @@
+// Command admin runs operational tasks straight against the snippetbox
+// database, using the same models as the web server. For example
+//
+//	admin -dsn 'web:pass@/snippetbox?parseTime=true' reset-password alice@example.com
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dwang288/snippetbox/internal/cli"
+	"github.com/dwang288/snippetbox/internal/ipaddr"
+	"github.com/dwang288/snippetbox/internal/models"
+	"github.com/dwang288/snippetbox/internal/validator"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+const usage = `Usage: admin [-dsn DSN] <command> [flags] [args]
+
+Commands:
+  create-user -name NAME -email EMAIL   create a user
+  reset-password EMAIL                  set a new password for a user and log them out everywhere
+  disable-user EMAIL                    stop a user from logging in or using API tokens
+  enable-user EMAIL                     undo disable-user
+  verify-user EMAIL                     mark a user's email address as verified
+  unlock-user EMAIL                     unblock logins to an email address after failed attempts
+  unlock-ip IP                          unblock logins from an IP address after failed attempts
+  delete-snippet ID|SLUG                delete any snippet
+  purge-expired                         delete expired snippets, sessions, password resets and login failures now
+  stats                                 print the number of users, snippets and sessions
+
+Commands that set a password make one up and print it, unless -password-stdin
+is given to read it from the first line of stdin instead. Like a reset from the
+website, reset-password also ends the user's sessions and revokes their API
+tokens. Run admin <command> -h for the flags of a command.
+`
+
+// Dependencies shared by every command
+type admin struct {
+	users         *models.UserModel
+	snippets      *models.SnippetModel
+	sessions      *models.SessionModel
+	tokens        *models.TokenModel
+	loginFailures *models.LoginFailureModel
+}
+
+func main() {
+	// Same default as the web server
+	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true", "MySQL data source name")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage+"\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	commands := map[string]func(a *admin, args []string) error{
+		"create-user":    (*admin).createUser,
+		"reset-password": (*admin).resetPassword,
+		"disable-user":   (*admin).disableUser,
+		"enable-user":    (*admin).enableUser,
+		"verify-user":    (*admin).verifyUser,
+		"unlock-user":    (*admin).unlockUser,
+		"unlock-ip":      (*admin).unlockIP,
+		"delete-snippet": (*admin).deleteSnippet,
+		"purge-expired":  (*admin).purgeExpired,
+		"stats":          (*admin).stats,
+	}
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	a := &admin{
+		users:         &models.UserModel{DB: db},
+		snippets:      &models.SnippetModel{DB: db},
+		sessions:      &models.SessionModel{DB: db},
+		tokens:        &models.TokenModel{DB: db},
+		loginFailures: &models.LoginFailureModel{DB: db},
+	}
+
+	err = command(a, flag.Args()[1:])
+	if err != nil {
+		// -h prints the usage of the command, which is all that's needed
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		os.Exit(1)
+	}
+}
+
+// openDB wraps sql.Open() and checks that the database can be reached
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Parses the arguments of a command that takes a single email address
+func emailArg(fs *flag.FlagSet, args []string) (string, error) {
+	positional, err := cli.ParseArgs(fs, args)
+	if err != nil {
+		return "", err
+	}
+	if len(positional) != 1 {
+		return "", fmt.Errorf("usage: admin %s EMAIL", fs.Name())
+	}
+	return positional[0], nil
+}
+
+// Returns the password to set. It's read from stdin if fromStdin is set,
+// otherwise a random one is made up and printed for the admin to pass on
+func newPassword(fromStdin bool) (string, error) {
+	if fromStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		password := strings.TrimRight(line, "\r\n")
+		// Same rule as the signup and change password forms
+		if !validator.MinChars(password, 8) {
+			return "", errors.New("the password must be at least 8 characters long")
+		}
+		return password, nil
+	}
+
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(b)
+	fmt.Printf("Password: %s\n", password)
+	return password, nil
+}
+
+// Looks up a user by email, with a friendlier error if there's no such user
+func (a *admin) userByEmail(email string) (*models.User, error) {
+	user, err := a.users.GetByEmail(email)
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil, fmt.Errorf("there's no user with the email %s", email)
+	}
+	return user, err
+}
+
+func (a *admin) createUser(args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ContinueOnError)
+	name := fs.String("name", "", "Name of the user")
+	email := fs.String("email", "", "Email address the user logs in with")
+	fromStdin := fs.Bool("password-stdin", false, "Read the password from stdin instead of making one up")
+	_, err := cli.ParseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	// Same checks as the signup form
+	v := validator.Validator{}
+	v.CheckField(validator.NotBlank(*name), "-name", "This field cannot be blank")
+	v.CheckField(validator.Matches(*email, validator.EmailRX), "-email", "This field must be a valid email address")
+	if !v.Valid() {
+		msg := "invalid user:"
+		for _, key := range []string{"-name", "-email"} {
+			if e, ok := v.FieldErrors[key]; ok {
+				msg += fmt.Sprintf("\n  %s: %s", key, e)
+			}
+		}
+		return errors.New(msg)
+	}
+
+	password, err := newPassword(*fromStdin)
+	if err != nil {
+		return err
+	}
+
+	id, err := a.users.Insert(*name, *email, password)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			return fmt.Errorf("the email %s is already in use", *email)
+		}
+		return err
+	}
+
+	// The admin vouches for the address, so no verification email is needed
+	err = a.users.Verify(id, *email)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created user %s\n", *email)
+	return nil
+}
+
+func (a *admin) resetPassword(args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	fromStdin := fs.Bool("password-stdin", false, "Read the password from stdin instead of making one up")
+	email, err := emailArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.userByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	password, err := newPassword(*fromStdin)
+	if err != nil {
+		return err
+	}
+
+	err = a.users.UpdateHashedPassword(user.ID, password)
+	if err != nil {
+		return err
+	}
+
+	// Passwords are usually reset because someone else may know the old one,
+	// so everything they could have logged in with goes, as in the web reset
+	err = a.users.LogOutEverywhere(user.ID)
+	if err != nil {
+		return err
+	}
+	tokens, err := a.tokens.DeleteByUser(user.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reset the password of %s, logged them out everywhere and revoked %d API tokens\n", user.Email, tokens)
+	return nil
+}
+
+func (a *admin) disableUser(args []string) error {
+	return a.setDisabled("disable-user", args, true)
+}
+
+func (a *admin) enableUser(args []string) error {
+	return a.setDisabled("enable-user", args, false)
+}
+
+// Disabling a user logs them out, since their sessions and API tokens are
+// only accepted for users that aren't disabled
+func (a *admin) setDisabled(name string, args []string, disabled bool) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	email, err := emailArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.userByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	err = a.users.SetDisabled(user.ID, disabled)
+	if err != nil {
+		return err
+	}
+
+	if disabled {
+		fmt.Printf("Disabled %s\n", user.Email)
+	} else {
+		fmt.Printf("Enabled %s\n", user.Email)
+	}
+	return nil
+}
+
+// For users whose verification emails don't arrive
+func (a *admin) verifyUser(args []string) error {
+	fs := flag.NewFlagSet("verify-user", flag.ContinueOnError)
+	email, err := emailArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.userByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	err = a.users.Verify(user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Verified %s\n", user.Email)
+	return nil
+}
+
+// Lets a user log in again straight away after failed attempts blocked them.
+// Addresses without an account can be blocked too, but there's no need to
+// unlock those
+func (a *admin) unlockUser(args []string) error {
+	fs := flag.NewFlagSet("unlock-user", flag.ContinueOnError)
+	email, err := emailArg(fs, args)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.userByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	cleared, err := a.loginFailures.Clear(models.LoginFailureEmail, user.Email)
+	if err != nil {
+		return err
+	}
+	if cleared {
+		fmt.Printf("Unlocked %s\n", user.Email)
+	} else {
+		fmt.Printf("%s has no failed logins\n", user.Email)
+	}
+	return nil
+}
+
+// For offices whose shared IP address got blocked
+func (a *admin) unlockIP(args []string) error {
+	fs := flag.NewFlagSet("unlock-ip", flag.ContinueOnError)
+	positional, err := cli.ParseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || net.ParseIP(positional[0]) == nil {
+		return errors.New("usage: admin unlock-ip IP")
+	}
+	// Failures are counted per network the way the server keys them, so any
+	// address in a blocked IPv6 /64 unlocks the whole network
+	ip := ipaddr.Network(positional[0])
+
+	cleared, err := a.loginFailures.Clear(models.LoginFailureIP, ip)
+	if err != nil {
+		return err
+	}
+	if cleared {
+		fmt.Printf("Unlocked %s\n", ip)
+	} else {
+		fmt.Printf("%s has no failed logins\n", ip)
+	}
+	return nil
+}
+
+func (a *admin) deleteSnippet(args []string) error {
+	fs := flag.NewFlagSet("delete-snippet", flag.ContinueOnError)
+	refs, err := cli.ParseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(refs) != 1 {
+		return errors.New("usage: admin delete-snippet ID|SLUG")
+	}
+
+	// Snippets are referred to by ID, or by slug like in their URLs. Expired
+	// snippets can be deleted too
+	ref := refs[0]
+	id, err := strconv.Atoi(ref)
+	if err != nil {
+		id, err = a.snippets.IDBySlug(ref)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				return fmt.Errorf("there's no snippet %s", ref)
+			}
+			return err
+		}
+	}
+
+	err = a.snippets.Delete(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return fmt.Errorf("there's no snippet %s", ref)
+		}
+		return err
+	}
+	fmt.Printf("Deleted snippet %d\n", id)
+	return nil
+}
+
+// Does what the web server's reaper does, for when it's been turned off or
+// a backlog needs clearing straight away
+func (a *admin) purgeExpired(args []string) error {
+	fs := flag.NewFlagSet("purge-expired", flag.ContinueOnError)
+	batch := fs.Int("batch", 1000, "Maximum number of expired rows deleted per query")
+	_, err := cli.ParseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *batch <= 0 {
+		return errors.New("-batch must be positive")
+	}
+
+	snippets, err := purge(a.snippets.DeleteExpired, *batch)
+	if err != nil {
+		return fmt.Errorf("purging snippets: %w", err)
+	}
+	sessions, err := purge(a.sessions.DeleteExpired, *batch)
+	if err != nil {
+		return fmt.Errorf("purging sessions: %w", err)
+	}
+	resets, err := purge(a.users.DeleteExpiredPasswordResets, *batch)
+	if err != nil {
+		return fmt.Errorf("purging password resets: %w", err)
+	}
+	failures, err := purge(a.loginFailures.DeleteExpired, *batch)
+	if err != nil {
+		return fmt.Errorf("purging login failures: %w", err)
+	}
+
+	fmt.Printf("Deleted %d expired snippets, %d expired sessions, %d expired password resets and %d old login failure counts\n", snippets, sessions, resets, failures)
+	return nil
+}
+
+// Calls deleteExpired until it deletes less than a full batch and returns the
+// number of rows deleted
+func purge(deleteExpired func(limit int) (int, error), batch int) (int, error) {
+	total := 0
+	for {
+		n, err := deleteExpired(batch)
+		total += n
+		if err != nil || n < batch {
+			return total, err
+		}
+	}
+}
+
+func (a *admin) stats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	_, err := cli.ParseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	users, err := a.users.Stats()
+	if err != nil {
+		return err
+	}
+	snippets, err := a.snippets.Stats()
+	if err != nil {
+		return err
+	}
+	sessions, err := a.sessions.Count()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Users\t%d\t(%d disabled)\n", users.Total, users.Disabled)
+	fmt.Fprintf(tw, "Snippets\t%d\t(%d public, %d unlisted, %d private)\n",
+		snippets.Total, snippets.Public, snippets.Unlisted, snippets.Private)
+	fmt.Fprintf(tw, "Expired snippets\t%d\t(not deleted yet)\n", snippets.Expired)
+	fmt.Fprintf(tw, "Active sessions\t%d\t\n", sessions)
+	return tw.Flush()
+}
@@ -0,0 +1,46 @@
+// Command mockidp is a tiny OpenID Connect identity provider for trying out
+// single sign-on locally. It isn't secure and mustn't be used for anything
+// real: whoever opens the login page can be whoever they like. For example
+//
+//	mockidp -addr :4010
+//	web -oidc-issuer http://localhost:4010 -oidc-client-id snippetbox -oidc-client-secret secret
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dwang288/snippetbox/internal/mockidp"
+)
+
+func main() {
+	addr := flag.String("addr", ":4010", "HTTP network address")
+	issuer := flag.String("issuer", "http://localhost:4010", "Issuer URL, which must be the URL the provider is reached at")
+	clientID := flag.String("client-id", "snippetbox", "Client ID the web server uses")
+	clientSecret := flag.String("client-secret", "secret", "Client secret the web server uses")
+	flag.Parse()
+
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	p, err := mockidp.New(*issuer, *clientID, *clientSecret, infoLog)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:         *addr,
+		ErrorLog:     errorLog,
+		Handler:      p.Routes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	infoLog.Printf("Starting mock identity provider %s on %s", *issuer, *addr)
+	err = srv.ListenAndServe()
+	errorLog.Fatal(err)
+}
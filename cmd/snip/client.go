@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dwang288/snippetbox/internal/api"
+)
+
+// client talks to the JSON API of a snippetbox server
+type client struct {
+	server string // Base URL of the server, e.g. https://localhost:4000
+	token  string // API token sent with every request, if set
+	http   *http.Client
+}
+
+// Returns a client for the server. insecure skips checking the server's TLS
+// certificate, for development servers with a self-signed one
+func newClient(server, token string, insecure bool) *client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &client{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		http:   &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+}
+
+// apiError is an error response from the server
+type apiError struct {
+	status int
+	api.ErrorResponse
+}
+
+// Error lists what the server said was wrong, one field per line
+func (e *apiError) Error() string {
+	msg := e.ErrorResponse.Error
+	if msg == "" {
+		msg = http.StatusText(e.status)
+	}
+
+	keys := make([]string, 0, len(e.FieldErrors))
+	for key := range e.FieldErrors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		msg += fmt.Sprintf("\n  %s: %s", key, e.FieldErrors[key])
+	}
+	for _, nonFieldError := range e.NonFieldErrors {
+		msg += "\n  " + nonFieldError
+	}
+	return msg
+}
+
+// Sends a request to the API and decodes the JSON response into dst. body is
+// sent as JSON if it isn't nil, and dst can be nil for responses without a body
+func (c *client) do(method, path string, body, dst any) error {
+	var reader io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		e := &apiError{status: resp.StatusCode}
+		// Errors that don't come from the API itself, like a proxy's, have no
+		// JSON body and are reported by their status alone
+		json.NewDecoder(resp.Body).Decode(&e.ErrorResponse)
+		return e
+	}
+
+	if dst == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+// Returns the user the token belongs to and the token's scopes
+func (c *client) account() (*api.AccountResponse, error) {
+	var resp api.AccountResponse
+	err := c.do(http.MethodGet, "/api/v1/account", nil, &resp)
+	return &resp, err
+}
+
+// Returns the snippet with the given ID or slug
+func (c *client) get(ref string) (*api.SnippetResponse, error) {
+	var resp api.SnippetResponse
+	err := c.do(http.MethodGet, "/api/v1/snippets/"+url.PathEscape(ref), nil, &resp)
+	return &resp, err
+}
+
+// Returns a page of public snippets. query holds the sort, tag and cursor
+func (c *client) list(query url.Values) (*api.SnippetListResponse, error) {
+	var resp api.SnippetListResponse
+	path := "/api/v1/snippets"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	err := c.do(http.MethodGet, path, nil, &resp)
+	return &resp, err
+}
+
+// Returns a page of the token user's own snippets, including unlisted and
+// private ones. query holds the sort, tag and cursor
+func (c *client) listOwn(query url.Values) (*api.SnippetListResponse, error) {
+	var resp api.SnippetListResponse
+	path := "/api/v1/account/snippets"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	err := c.do(http.MethodGet, path, nil, &resp)
+	return &resp, err
+}
+
+// Creates a snippet owned by the token's user
+func (c *client) create(req *api.SnippetRequest) (*api.SnippetResponse, error) {
+	var resp api.SnippetResponse
+	err := c.do(http.MethodPost, "/api/v1/snippets", req, &resp)
+	return &resp, err
+}
+
+// Deletes one of the token user's snippets
+func (c *client) delete(ref string) error {
+	return c.do(http.MethodDelete, "/api/v1/snippets/"+url.PathEscape(ref), nil, nil)
+}
@@ -0,0 +1,447 @@
+// Command snip is a command line client for snippetbox. It talks to the
+// server's JSON API using a personal API token, which `snip login` stores in
+// the user's config directory
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dwang288/snippetbox/internal/api"
+	"github.com/dwang288/snippetbox/internal/cli"
+	"github.com/dwang288/snippetbox/internal/models"
+)
+
+const usage = `Usage: snip <command> [flags] [args]
+
+Commands:
+  login                 store the server and an API token to use
+  create [file...]      create a snippet from files, or stdin if none are given
+  get <id>              print a snippet
+  list                  list public snippets, or your own with -mine
+  delete <id>           delete one of your snippets
+
+Every command takes -server, -insecure and -json. Run snip <command> -h
+for the flags of a command.
+`
+
+// Settings saved by snip login
+type config struct {
+	Server   string `json:"server"`
+	Token    string `json:"token"`
+	Insecure bool   `json:"insecure,omitempty"`
+}
+
+// Server used when none has been configured, the default address of cmd/web
+const defaultServer = "https://localhost:4000"
+
+// Flags shared by every command
+type options struct {
+	server   string
+	insecure bool
+	json     bool
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	commands := map[string]func(args []string) error{
+		"login":  login,
+		"create": create,
+		"get":    get,
+		"list":   list,
+		"delete": deleteSnippet,
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	err := command(os.Args[2:])
+	if err != nil {
+		// -h prints the usage of the command, which is all that's needed
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "snip:", err)
+		os.Exit(1)
+	}
+}
+
+// Returns a flag set for a command with the flags every command shares
+func newFlagSet(name string) (*flag.FlagSet, *options) {
+	fs := flag.NewFlagSet("snip "+name, flag.ContinueOnError)
+	opts := &options{}
+	fs.StringVar(&opts.server, "server", "", "URL of the snippetbox server (default from snip login, or "+defaultServer+")")
+	fs.BoolVar(&opts.insecure, "insecure", false, "Don't check the server's TLS certificate, e.g. for a self-signed development one")
+	fs.BoolVar(&opts.json, "json", false, "Print the API's JSON instead of human readable output")
+	return fs, opts
+}
+
+// Returns the path of the config file, in the user's config directory
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "snip", "config.json"), nil
+}
+
+// Reads the config saved by snip login. A missing file is an empty config
+func loadConfig() (*config, error) {
+	cfg := &config{}
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Writes the config so that only the user can read the token in it
+func saveConfig(cfg *config) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o600)
+}
+
+// Returns a client set up from the saved config, overridden by the flags and
+// the SNIP_SERVER and SNIP_TOKEN environment variables
+func newClientFromConfig(opts *options) (*client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	server := cfg.Server
+	if v := os.Getenv("SNIP_SERVER"); v != "" {
+		server = v
+	}
+	if opts.server != "" {
+		server = opts.server
+	}
+	if server == "" {
+		server = defaultServer
+	}
+
+	token := cfg.Token
+	if v := os.Getenv("SNIP_TOKEN"); v != "" {
+		token = v
+	}
+
+	return newClient(server, token, cfg.Insecure || opts.insecure), nil
+}
+
+// Prints v as indented JSON
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	return enc.Encode(v)
+}
+
+// snip login asks for an API token, checks it against the server and saves both
+func login(args []string) error {
+	fs, opts := newFlagSet("login")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: snip login [-server URL] [-insecure]\n\nCreate a token on your account page first.")
+		fs.PrintDefaults()
+	}
+	_, err := cli.ParseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if opts.server != "" {
+		cfg.Server = opts.server
+	}
+	if cfg.Server == "" {
+		cfg.Server = defaultServer
+	}
+	cfg.Insecure = cfg.Insecure || opts.insecure
+
+	fmt.Fprintf(os.Stderr, "API token for %s: ", cfg.Server)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	cfg.Token = strings.TrimSpace(line)
+	if cfg.Token == "" {
+		return errors.New("no token given")
+	}
+
+	// Every valid token can read the account, whatever its scopes
+	c := newClient(cfg.Server, cfg.Token, cfg.Insecure)
+	resp, err := c.account()
+	if err != nil {
+		return fmt.Errorf("checking the token: %w", err)
+	}
+
+	err = saveConfig(cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Logged in to %s as %s (scopes: %s)\n", cfg.Server, resp.Account.Email, strings.Join(resp.Scopes, ", "))
+	return nil
+}
+
+// snip create reads a snippet from files or stdin. The first file is the
+// snippet's content and any others are bundled with it
+func create(args []string) error {
+	fs, opts := newFlagSet("create")
+	req := &api.SnippetRequest{}
+	var tags string
+	fs.StringVar(&req.Title, "title", "", "Title of the snippet (default the first file's name)")
+	fs.StringVar(&req.Language, "language", "", "Language of the content (default detected)")
+	fs.StringVar(&req.Format, "format", "", "code or markdown (default code)")
+	fs.StringVar(&req.Expires, "expires", "", "Days until the snippet expires: 1, 7, 365 or never (default 365)")
+	fs.StringVar(&req.Visibility, "visibility", "", "public, unlisted or private (default public)")
+	fs.StringVar(&tags, "tags", "", "Comma separated tags")
+	fs.IntVar(&req.MaxViews, "views", 0, "Delete the snippet after this many views")
+	fs.BoolVar(&req.BurnAfterReading, "burn", false, "Delete the snippet after it's read once")
+	files, err := cli.ParseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if tags != "" {
+		req.Tags = strings.Split(tags, ",")
+	}
+
+	if len(files) == 0 {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		req.Content = string(b)
+		if req.Title == "" {
+			req.Title = "Untitled"
+		}
+	}
+	for i, name := range files {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			req.Content = string(b)
+			if req.Title == "" {
+				req.Title = filepath.Base(name)
+			}
+			continue
+		}
+		req.Files = append(req.Files, &models.SnippetFile{Name: filepath.Base(name), Content: string(b)})
+	}
+
+	c, err := newClientFromConfig(opts)
+	if err != nil {
+		return err
+	}
+	resp, err := c.create(req)
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return printJSON(resp)
+	}
+	fmt.Printf("%s/snippet/view/%s\n", c.server, resp.Snippet.Ref())
+	return nil
+}
+
+// snip get prints a snippet's content, followed by any other files in it
+func get(args []string) error {
+	fs, opts := newFlagSet("get")
+	refs, err := cli.ParseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(refs) != 1 {
+		return errors.New("usage: snip get <id>")
+	}
+
+	c, err := newClientFromConfig(opts)
+	if err != nil {
+		return err
+	}
+	resp, err := c.get(refs[0])
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return printJSON(resp)
+	}
+
+	// A snippet on its own is printed as is, so it can be piped into a file
+	s := resp.Snippet
+	if len(s.Files) == 0 {
+		fmt.Print(s.Content)
+		return nil
+	}
+	fmt.Printf("==> %s <==\n%s\n", s.Title, s.Content)
+	for _, f := range s.Files {
+		fmt.Printf("\n==> %s <==\n%s\n", f.Name, f.Content)
+	}
+	return nil
+}
+
+// snip list prints a page of public snippets, or of the user's own snippets
+func list(args []string) error {
+	fs, opts := newFlagSet("list")
+	query := url.Values{}
+	var sort, tag, after, before string
+	var mine bool
+	fs.BoolVar(&mine, "mine", false, "List your own snippets, including unlisted and private ones")
+	fs.StringVar(&sort, "sort", "", "created or expires (default created)")
+	fs.StringVar(&tag, "tag", "", "Only list snippets with this tag")
+	fs.StringVar(&after, "after", "", "List the page after this cursor, as printed below the previous page")
+	fs.StringVar(&before, "before", "", "List the page before this cursor")
+	_, err := cli.ParseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	for key, value := range map[string]string{"sort": sort, "tag": tag, "after": after, "before": before} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	c, err := newClientFromConfig(opts)
+	if err != nil {
+		return err
+	}
+	fetch := c.list
+	if mine {
+		fetch = c.listOwn
+	}
+	resp, err := fetch(query)
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return printJSON(resp)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCREATED\tEXPIRES\tVISIBILITY\tTITLE")
+	for _, s := range resp.Snippets {
+		expires := "never"
+		if s.Expires != nil {
+			expires = s.Expires.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Ref(), s.Created.Format("2006-01-02 15:04"), expires, s.Visibility, s.Title)
+	}
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	// Tell the user how to get to the pages on either side
+	if resp.Prev != nil || resp.Next != nil {
+		fmt.Fprintln(os.Stderr)
+	}
+	if cmd, ok := pageCommand(resp.Prev, mine); ok {
+		fmt.Fprintf(os.Stderr, "Previous: %s\n", cmd)
+	}
+	if cmd, ok := pageCommand(resp.Next, mine); ok {
+		fmt.Fprintf(os.Stderr, "More: %s\n", cmd)
+	}
+	return nil
+}
+
+// Returns the snip list command that fetches the page at the link the server
+// gave. Every parameter of the link is passed on, since a cursor only means
+// something under the sort and tag it was made for
+func pageCommand(link *string, mine bool) (string, bool) {
+	if link == nil {
+		return "", false
+	}
+	u, err := url.Parse(*link)
+	if err != nil {
+		return "", false
+	}
+
+	cmd := "snip list"
+	if mine {
+		cmd += " -mine"
+	}
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cmd += fmt.Sprintf(" -%s %s", key, query.Get(key))
+	}
+	return cmd, true
+}
+
+// snip delete deletes one of the user's snippets
+func deleteSnippet(args []string) error {
+	fs, opts := newFlagSet("delete")
+	refs, err := cli.ParseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(refs) != 1 {
+		return errors.New("usage: snip delete <id>")
+	}
+
+	c, err := newClientFromConfig(opts)
+	if err != nil {
+		return err
+	}
+	err = c.delete(refs[0])
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return printJSON(map[string]string{"deleted": refs[0]})
+	}
+	fmt.Printf("Deleted snippet %s\n", refs[0])
+	return nil
+}
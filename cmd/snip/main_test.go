@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestPageCommand(t *testing.T) {
+	link := func(s string) *string { return &s }
+
+	tests := []struct {
+		name   string
+		link   *string
+		mine   bool
+		want   string
+		wantOK bool
+	}{
+		{
+			name: "No page",
+		},
+		{
+			name:   "Next page",
+			link:   link("/api/v1/snippets?after=1709296200_42"),
+			want:   "snip list -after 1709296200_42",
+			wantOK: true,
+		},
+		{
+			name:   "Sorted and tagged",
+			link:   link("/api/v1/snippets?after=1709296200_42&sort=expires&tag=go"),
+			want:   "snip list -after 1709296200_42 -sort expires -tag go",
+			wantOK: true,
+		},
+		{
+			name:   "Own snippets before",
+			link:   link("/api/v1/account/snippets?before=1709296200_42&sort=expires"),
+			mine:   true,
+			want:   "snip list -mine -before 1709296200_42 -sort expires",
+			wantOK: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := pageCommand(tt.link, tt.mine)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("got %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
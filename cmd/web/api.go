@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/dwang288/snippetbox/internal/api"
+	"github.com/dwang288/snippetbox/internal/models"
+	"github.com/dwang288/snippetbox/internal/validator"
+)
+
+// JSON API for snippets, served under /api/v1. The handlers go through the same
+// SnippetModel methods and validation rules as the HTML pages, but every
+// response, including errors, is JSON
+
+// Largest request body the API accepts, plenty for a snippet and its files
+const maxRequestBytes = 1 << 20
+
+// Converts the request into the form used by the HTML pages, so that it goes
+// through exactly the same validation. Exact expiry times are cut down to the
+// minute, like the ones picked in the form
+func requestForm(req *api.SnippetRequest, editing bool) *snippetCreateForm {
+	form := &snippetCreateForm{
+		Title:            req.Title,
+		Content:          req.Content,
+		Expires:          req.Expires,
+		Tags:             strings.Join(req.Tags, ","),
+		Language:         req.Language,
+		Format:           req.Format,
+		Visibility:       req.Visibility,
+		MaxViews:         req.MaxViews,
+		BurnAfterReading: req.BurnAfterReading,
+		Editing:          editing,
+	}
+
+	if req.ExpiresAt != nil {
+		form.ExpiresAt = req.ExpiresAt.UTC().Format(dateTimeLocalLayout)
+		if form.Expires == "" {
+			form.Expires = expiresCustom
+		}
+	}
+	if form.Expires == "" {
+		form.Expires = "365"
+		if editing {
+			form.Expires = expiresKeep
+		}
+	}
+	if form.Format == "" {
+		form.Format = models.FormatCode
+	}
+	if form.Visibility == "" {
+		form.Visibility = models.VisibilityPublic
+	}
+
+	for _, file := range req.Files {
+		form.Files = append(form.Files, snippetFileForm{
+			Name:     file.Name,
+			Language: file.Language,
+			Content:  file.Content,
+		})
+	}
+	return form
+}
+
+// GET /api/v1/snippets lists public snippets a page at a time. Takes the same
+// sort, after, before and tag query parameters as the HTML listing
+func (app *application) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	app.apiSnippetPage(w, r, 0)
+}
+
+// GET /api/v1/account/snippets lists the authenticated user's own snippets,
+// including unlisted and private ones. Takes the same query parameters as
+// GET /api/v1/snippets
+func (app *application) apiAccountSnippets(w http.ResponseWriter, r *http.Request) {
+	app.apiSnippetPage(w, r, app.authenticatedUserID(r))
+}
+
+// Writes a page of public snippets, or of the user's snippets if userID is set
+func (app *application) apiSnippetPage(w http.ResponseWriter, r *http.Request, userID int) {
+	opts, err := listOptions(r)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.Tag = strings.ToLower(r.URL.Query().Get("tag"))
+	opts.UserID = userID
+
+	page, err := app.snippets.List(opts)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	// Links to the pages on either side are null when there's no such page
+	var prev, next *string
+	if n := len(page.Snippets); n > 0 {
+		if page.HasPrev {
+			u := pageCursorURL(r, "before", opts.Sort, page.Snippets[0])
+			prev = &u
+		}
+		if page.HasNext {
+			u := pageCursorURL(r, "after", opts.Sort, page.Snippets[n-1])
+			next = &u
+		}
+	}
+	for _, snippet := range page.Snippets {
+		app.hideSlug(r, snippet)
+	}
+
+	app.writeJSON(w, http.StatusOK, api.SnippetListResponse{Snippets: page.Snippets, Prev: prev, Next: next})
+}
+
+// GET /api/v1/account returns the authenticated user. Every valid token can
+// read it whatever its scopes, so clients can use it to check a token
+func (app *application) apiAccount(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	resp := api.AccountResponse{Account: &api.Account{ID: user.ID, Name: user.Name, Email: user.Email}}
+	if token, ok := r.Context().Value(tokenContextKey).(*models.Token); ok {
+		resp.Scopes = token.Scopes
+	}
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// GET /api/v1/snippets/:id returns a snippet with its content and files. Reading
+// a view-limited snippet counts as a view, just like the HTML page
+func (app *application) apiSnippetView(w http.ResponseWriter, r *http.Request) {
+	snippet, _, err := app.readSnippet(w, r)
+	if err != nil {
+		app.apiSnippetError(w, err)
+		return
+	}
+
+	app.hideSlug(r, snippet)
+	app.writeJSON(w, http.StatusOK, api.SnippetResponse{Snippet: snippet})
+}
+
+// POST /api/v1/snippets creates a snippet owned by the authenticated user
+func (app *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	var req api.SnippetRequest
+	err := app.readJSON(w, r, &req)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	form := requestForm(&req, false)
+	form.validate()
+	if !form.Valid() {
+		app.apiValidationError(w, form.Validator)
+		return
+	}
+
+	snippet := form.snippet()
+	snippet.UserID = app.authenticatedUserID(r)
+	snippet.Expires = form.expiry(nil)
+
+	_, err = app.snippets.Insert(snippet)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	// Read the snippet back so the response has everything a GET would
+	snippet, err = app.snippets.Get(snippet.ID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/snippets/%s", snippet.Ref()))
+	app.writeJSON(w, http.StatusCreated, api.SnippetResponse{Snippet: snippet})
+}
+
+// PUT /api/v1/snippets/:id updates a snippet with the request body. Only its
+// author may update it. Fields that are left out keep their current values
+func (app *application) apiSnippetUpdate(w http.ResponseWriter, r *http.Request) {
+	snippet, err := app.authoredSnippet(r)
+	if err != nil {
+		app.apiSnippetError(w, err)
+		return
+	}
+
+	req, err := app.readSnippetUpdate(w, r, snippet)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	form := requestForm(req, true)
+	form.validate()
+	if !form.Valid() {
+		app.apiValidationError(w, form.Validator)
+		return
+	}
+
+	updated := form.snippet()
+	updated.ID = snippet.ID
+	updated.Slug = snippet.Slug
+	updated.Expires = form.expiry(snippet.Expires)
+
+	err = app.snippets.Update(updated, app.authenticatedUserID(r))
+	if err != nil {
+		app.apiSnippetError(w, err)
+		return
+	}
+
+	updated, err = app.snippets.Get(snippet.ID)
+	if err != nil {
+		app.apiSnippetError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, api.SnippetResponse{Snippet: updated})
+}
+
+// Decodes the body of an update over the snippet's current values, so that a
+// field the body leaves out isn't reset to its default. A snippet with a
+// private visibility stays private when only its content is sent, for example
+func (app *application) readSnippetUpdate(w http.ResponseWriter, r *http.Request, snippet *models.Snippet) (*api.SnippetRequest, error) {
+	req := &api.SnippetRequest{
+		Title:      snippet.Title,
+		Content:    snippet.Content,
+		Tags:       append([]string(nil), snippet.Tags...),
+		Language:   snippet.Language,
+		Format:     snippet.Format,
+		Visibility: snippet.Visibility,
+		MaxViews:   snippet.MaxViews,
+	}
+
+	err := app.readJSON(w, r, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files aren't filled in before decoding, since the decoder would merge
+	// each file in the body with the current file in its place. An empty list
+	// removes the files, a missing one keeps them
+	if req.Files == nil {
+		req.Files = snippet.Files
+	}
+	return req, nil
+}
+
+// DELETE /api/v1/snippets/:id deletes a snippet. Only its author may delete it
+func (app *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	snippet, err := app.authoredSnippet(r)
+	if err != nil {
+		app.apiSnippetError(w, err)
+		return
+	}
+
+	err = app.snippets.Delete(snippet.ID)
+	if err != nil {
+		app.apiSnippetError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Clears the slug of a public snippet unless the user is its author. Public
+// snippets are only ever linked to by ID, so that their slug stays secret in
+// case they're made unlisted later on
+func (app *application) hideSlug(r *http.Request, snippet *models.Snippet) {
+	if snippet.Visibility == models.VisibilityPublic && !app.isAuthor(r, snippet) {
+		snippet.Slug = ""
+	}
+}
+
+// Decodes a JSON request body into dst. Unknown fields, trailing data and
+// bodies over maxRequestBytes are rejected with an error fit for the client
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		var syntaxError *json.SyntaxError
+		var typeError *json.UnmarshalTypeError
+		var invalidUnmarshalError *json.InvalidUnmarshalError
+		switch {
+		// Panic if we pass in an invalid target destination, like decodePostForm
+		case errors.As(err, &invalidUnmarshalError):
+			panic(err)
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &typeError):
+			return fmt.Errorf("body contains the wrong type for field %q", typeError.Field)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		default:
+			return err
+		}
+	}
+
+	// A second decode only succeeds if there's more than one JSON value
+	if dec.Decode(&struct{}{}) != io.EOF {
+		return errors.New("body must only contain a single JSON value")
+	}
+	return nil
+}
+
+// Writes data as the JSON response with the given status code
+func (app *application) writeJSON(w http.ResponseWriter, status int, data any) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// Writes an error response like {"error": "message"}
+func (app *application) apiError(w http.ResponseWriter, status int, message string) {
+	app.writeJSON(w, status, api.ErrorResponse{Error: message})
+}
+
+// The JSON version of serverError. The details are only logged
+func (app *application) apiServerError(w http.ResponseWriter, err error) {
+	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
+	app.errorLog.Output(2, trace)
+
+	app.apiError(w, http.StatusInternalServerError, "the server encountered a problem and could not process your request")
+}
+
+// Writes the errors of a form that failed validation, keyed the same way as
+// on the HTML form
+func (app *application) apiValidationError(w http.ResponseWriter, v validator.Validator) {
+	app.writeJSON(w, http.StatusUnprocessableEntity, api.ErrorResponse{
+		Error:          "the snippet is invalid",
+		FieldErrors:    v.FieldErrors,
+		NonFieldErrors: v.NonFieldErrors,
+	})
+}
+
+// The JSON version of snippetError
+func (app *application) apiSnippetError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		app.apiError(w, http.StatusNotFound, "the snippet could not be found")
+	case errors.Is(err, errNotAuthor):
+		app.apiError(w, http.StatusForbidden, "only the author of the snippet can change it")
+	default:
+		app.apiServerError(w, err)
+	}
+}
+
+// Answers a request with a missing or bad API token
+func (app *application) invalidTokenResponse(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	app.apiError(w, http.StatusUnauthorized, "invalid or expired authentication token")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dwang288/snippetbox/internal/api"
+	"github.com/dwang288/snippetbox/internal/models"
+)
+
+func TestReadSnippetUpdate(t *testing.T) {
+	current := func() *models.Snippet {
+		return &models.Snippet{
+			Title:      "Title",
+			Content:    "Content",
+			Tags:       []string{"go", "sql"},
+			Language:   "Go",
+			Format:     models.FormatCode,
+			Visibility: models.VisibilityPrivate,
+			MaxViews:   5,
+			Files:      []*models.SnippetFile{{Name: "a.go", Language: "Go", Content: "package a"}},
+		}
+	}
+	unchanged := api.SnippetRequest{
+		Title:      "Title",
+		Content:    "Content",
+		Tags:       []string{"go", "sql"},
+		Language:   "Go",
+		Format:     models.FormatCode,
+		Visibility: models.VisibilityPrivate,
+		MaxViews:   5,
+		Files:      []*models.SnippetFile{{Name: "a.go", Language: "Go", Content: "package a"}},
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want func(req *api.SnippetRequest)
+	}{
+		{
+			name: "Only content",
+			body: `{"content": "New content"}`,
+			want: func(req *api.SnippetRequest) { req.Content = "New content" },
+		},
+		{
+			name: "Empty object",
+			body: `{}`,
+			want: func(req *api.SnippetRequest) {},
+		},
+		{
+			name: "Visibility",
+			body: `{"visibility": "public"}`,
+			want: func(req *api.SnippetRequest) { req.Visibility = models.VisibilityPublic },
+		},
+		{
+			name: "Cleared tags and view limit",
+			body: `{"tags": [], "maxViews": 0}`,
+			want: func(req *api.SnippetRequest) {
+				req.Tags = []string{}
+				req.MaxViews = 0
+			},
+		},
+		{
+			name: "Removed files",
+			body: `{"files": []}`,
+			want: func(req *api.SnippetRequest) { req.Files = []*models.SnippetFile{} },
+		},
+		{
+			name: "Replaced file",
+			body: `{"files": [{"name": "b.txt", "content": "b"}]}`,
+			want: func(req *api.SnippetRequest) {
+				req.Files = []*models.SnippetFile{{Name: "b.txt", Content: "b"}}
+			},
+		},
+	}
+
+	app := &application{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, "/api/v1/snippets/1", strings.NewReader(tt.body))
+
+			got, err := app.readSnippetUpdate(w, r, current())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := unchanged
+			want.Tags = append([]string(nil), unchanged.Tags...)
+			tt.want(&want)
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("got %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
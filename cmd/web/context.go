@@ -3,3 +3,9 @@ package main
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+
+// ID of the authenticated user, set along with isAuthenticatedContextKey
+const authenticatedUserIDContextKey = contextKey("authenticatedUserID")
+
+// *models.Token the request was authenticated with, only set for API tokens
+const tokenContextKey = contextKey("token")
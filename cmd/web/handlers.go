@@ -1,12 +1,21 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
+	"github.com/dwang288/snippetbox/internal/diff"
+	"github.com/dwang288/snippetbox/internal/ipaddr"
 	"github.com/dwang288/snippetbox/internal/models"
 	"github.com/dwang288/snippetbox/internal/validator"
 
@@ -32,8 +41,101 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	app.render(w, http.StatusOK, "home.tmpl.html", data)
 }
 
+// Number of snippets shown on each page of a listing
+const pageSize = 20
+
+// Lists every snippet on /snippets, or the snippets with a tag on /tag/:name
+func (app *application) snippetList(w http.ResponseWriter, r *http.Request) {
+	// The tag is empty on /snippets, which doesn't have a :name parameter
+	params := httprouter.ParamsFromContext(r.Context())
+
+	opts, err := listOptions(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	opts.Tag = strings.ToLower(params.ByName("name"))
+
+	page, err := app.snippets.List(opts)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = page.Snippets
+	data.Sort = opts.Sort
+	data.Tag = opts.Tag
+	data.Pagination = &pagination{}
+	if n := len(page.Snippets); n > 0 {
+		if page.HasPrev {
+			data.Pagination.Prev = pageCursorURL(r, "before", opts.Sort, page.Snippets[0])
+		}
+		if page.HasNext {
+			data.Pagination.Next = pageCursorURL(r, "after", opts.Sort, page.Snippets[n-1])
+		}
+	}
+
+	app.render(w, http.StatusOK, "list.tmpl.html", data)
+}
+
+func (app *application) search(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	page := 1
+	if v := r.URL.Query().Get("page"); v != "" {
+		var err error
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.Query = query
+
+	// A blank search just shows the empty search page
+	if query != "" {
+		results, err := app.snippets.Search(query, page)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		data.Snippets = results.Snippets
+		data.Pagination = &pagination{}
+		if results.HasPrev {
+			data.Pagination.Prev = pageURL(r, "page", strconv.Itoa(page-1))
+		}
+		if results.HasNext {
+			data.Pagination.Next = pageURL(r, "page", strconv.Itoa(page+1))
+		}
+	}
+
+	app.render(w, http.StatusOK, "search.tmpl.html", data)
+}
+
 func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
-	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	data, ok := app.accountData(w, r)
+	if !ok {
+		return
+	}
+
+	// A token that was just created is shown once, right after creating it
+	data.NewToken = app.sessionManager.PopString(r.Context(), "newToken")
+	data.Form = tokenCreateForm{
+		Scopes:  []string{models.ScopeRead},
+		Expires: "90",
+	}
+
+	app.render(w, http.StatusOK, "account.tmpl.html", data)
+}
+
+// Loads everything shown on the account page. If the user can't be found, a
+// response is written and ok is false
+func (app *application) accountData(w http.ResponseWriter, r *http.Request) (*templateData, bool) {
+	id := app.authenticatedUserID(r)
 
 	user, err := app.users.Get(id)
 	if err != nil {
@@ -42,31 +144,98 @@ func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
 		} else {
 			app.serverError(w, err)
 		}
+		return nil, false
+	}
+
+	// Grab the snippets this user has created so they can find them again
+	snippets, err := app.snippets.ByUser(id)
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+
+	tokens, err := app.tokens.ByUser(id)
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
 	}
 
 	data := app.newTemplateData(r)
 	data.User = user
+	data.Snippets = snippets
+	data.Tokens = tokens
+	return data, true
+}
 
-	app.render(w, http.StatusOK, "account.tmpl.html", data)
+// Form for creating a personal API token on the account page
+type tokenCreateForm struct {
+	Name   string   `form:"name"`
+	Scopes []string `form:"scopes"`
+	// Number of days until the token expires, or never
+	Expires             string `form:"expires"`
+	validator.Validator `form:"-"`
+}
 
+// Returns true if the scope is checked in the form
+func (form tokenCreateForm) HasScope(scope string) bool {
+	return validator.PermittedValue(scope, form.Scopes...)
 }
 
-func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
-	// Grab named parameters from request with ParamsFromContext(r.Context())
-	params := httprouter.ParamsFromContext(r.Context())
+func (app *application) accountTokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form tokenCreateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
 
-	// Extract the id parameter from the slice and turn the string into an int
-	id, err := strconv.Atoi(params.ByName("id"))
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+	form.CheckField(len(form.Scopes) > 0, "scopes", "Pick at least one scope")
+	for _, scope := range form.Scopes {
+		form.CheckField(validator.PermittedValue(scope, models.ScopeRead, models.ScopeWrite), "scopes", "Scopes must be read or write")
+	}
+	form.CheckField(validator.PermittedValue(form.Expires, "30", "90", "365", expiresNever), "expires", "This field must equal 30, 90, 365 or never")
+
+	if !form.Valid() {
+		data, ok := app.accountData(w, r)
+		if !ok {
+			return
+		}
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "account.tmpl.html", data)
+		return
+	}
+
+	var expires *time.Time
+	if form.Expires != expiresNever {
+		days, _ := strconv.Atoi(form.Expires)
+		t := time.Now().UTC().AddDate(0, 0, days)
+		expires = &t
+	}
+
+	token, err := app.tokens.Insert(app.authenticatedUserID(r), form.Name, form.Scopes, expires)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
-	// If it cannot be converted or is out of the expected range then return 404
+	// Only the hash is stored, so this is the one chance to copy the token
+	app.sessionManager.Put(r.Context(), "newToken", token)
+	app.sessionManager.Put(r.Context(), "flash", "Token created! Copy it now, it won't be shown again.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (app *application) accountTokenRevokePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
 	if err != nil || id < 1 {
-		app.notFound(w) // Use the notFound() helper
+		app.notFound(w)
 		return
 	}
 
-	// Retrieve the snippet data from the db with its id. If no record is found,
-	// return a 404. If it's some other error, throw a 500.
-	snippet, err := app.snippets.Get(id)
+	// Users can only revoke their own tokens
+	err = app.tokens.Delete(id, app.authenticatedUserID(r))
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFound(w)
@@ -76,14 +245,112 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.sessionManager.Put(r.Context(), "flash", "Token revoked.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
+	snippet, remainingViews, ok := app.viewSnippet(w, r)
+	if !ok {
+		return
+	}
+
 	// Create a new templateData struct and add the snippet to the struct
 	data := app.newTemplateData(r)
 	data.Snippet = snippet
 
+	// Link back to the original of a fork, unless it's gone or only reachable
+	// by a slug the reader might not know
+	if snippet.ForkedFrom != 0 {
+		parent, err := app.snippets.Get(snippet.ForkedFrom)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+		if err == nil && (parent.Visibility == models.VisibilityPublic || app.isAuthor(r, parent)) {
+			data.Parent = parent
+		}
+	}
+
+	forks, err := app.snippets.Forks(snippet.ID, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data.Snippets = forks
+
+	// Kept apart from the flash, which may still hold a message for this page
+	data.LastView = remainingViews == 0
+
 	// Use the render helper. Still passing in hardcoded page name
 	app.render(w, http.StatusOK, "view.tmpl.html", data)
 }
 
+// Serves the content of a snippet as plain text, e.g. for piping into a shell
+func (app *application) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	snippet, _, ok := app.viewSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeSnippetFile(w, snippet, "inline")
+}
+
+// Serves the content of a snippet as a file to save, named after its title
+// and language
+func (app *application) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	snippet, _, ok := app.viewSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeSnippetFile(w, snippet, "attachment")
+}
+
+// Serves every file in a snippet as a zip archive, the snippet's own content
+// first. Snippets without extra files come back as an archive of one file
+func (app *application) snippetZip(w http.ResponseWriter, r *http.Request) {
+	snippet, _, ok := app.viewSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	// Write the archive into a buffer first, so that an error can still be
+	// sent as a 500 instead of a truncated download
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	files := append([]*models.SnippetFile{{Name: snippetFilename(snippet), Content: snippet.Content}}, snippet.Files...)
+	for _, file := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.Name,
+			Method:   zip.Deflate,
+			Modified: snippet.Created,
+		})
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		_, err = io.WriteString(fw, file.Content)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	name := strings.TrimSuffix(snippetFilename(snippet), fileExtension(snippet.Language)) + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": name,
+	}))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	buf.WriteTo(w)
+}
+
 // Struct for holding form data
 // Fields are exported on purpose because html/template needs them to be
 // exported to be read
@@ -92,24 +359,280 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 type snippetCreateForm struct {
 	Title   string `form:"title"`
 	Content string `form:"content"`
-	Expires int    `form:"expires"`
+	// Number of days until the snippet expires, or one of the expires constants
+	Expires string `form:"expires"`
+	// Exact expiry in UTC when Expires is custom, in datetime-local format
+	ExpiresAt string `form:"expiresAt"`
+	// Comma or space separated list of tags
+	Tags string `form:"tags"`
+	// Left blank to have the language detected from the content
+	Language string `form:"language"`
+	// Either code or markdown
+	Format string `form:"format"`
+	// One of public, unlisted or private
+	Visibility string `form:"visibility"`
+	// Number of views before the snippet is deleted, 0 for no limit. Burning a
+	// snippet after reading is the same as a limit of one view
+	MaxViews         int  `form:"maxViews"`
+	BurnAfterReading bool `form:"burn"`
 	// Embeds the validator so that snippetCreateForm can use all the fields
 	// and methods of the Validator type
 	// Validator type contains the FieldsError field so we can access it the same way
 	// as we did before
 	validator.Validator `form:"-"`
+	// Set when the form edits an existing snippet, which can keep its expiry
+	Editing bool `form:"-"`
+	// Ref of the snippet being forked, if any. ForkedTitle is only for display
+	ForkedFrom  string `form:"forkedFrom"`
+	ForkedTitle string `form:"-"`
+	// Files bundled with the snippet besides Content, decoded from fields
+	// named like files[0].name
+	Files []snippetFileForm `form:"files"`
+	// Set by the buttons that add or remove a file, which show the form again
+	// instead of saving it. One of the action constants
+	Action string `form:"action"`
+}
+
+// A single extra file in snippetCreateForm
+type snippetFileForm struct {
+	Name string `form:"name"`
+	// Left blank to have the language detected from the name and content
+	Language string `form:"language"`
+	Content  string `form:"content"`
+}
+
+// Maximum number of files in a snippet, counting its own content
+const maxFiles = 10
+
+// Values of the action field besides saving the form
+const (
+	actionAddFile    = "add-file"
+	actionRemoveFile = "remove-file-" // Followed by the index of the file
+)
+
+// Returns true if there's room for another file in the form
+func (form snippetCreateForm) CanAddFile() bool {
+	return len(form.Files) < maxFiles-1
+}
+
+// Applies the action of a button that changes the files in the form. Returns
+// false if the form was submitted to be saved instead
+func (form *snippetCreateForm) applyAction() bool {
+	switch {
+	case form.Action == actionAddFile:
+		if form.CanAddFile() {
+			form.Files = append(form.Files, snippetFileForm{})
+		}
+	case strings.HasPrefix(form.Action, actionRemoveFile):
+		i, err := strconv.Atoi(strings.TrimPrefix(form.Action, actionRemoveFile))
+		if err == nil && i >= 0 && i < len(form.Files) {
+			form.Files = append(form.Files[:i], form.Files[i+1:]...)
+		}
+	default:
+		return false
+	}
+	form.Action = ""
+	return true
+}
+
+// Expiry choices besides the preset number of days
+const (
+	expiresNever  = "never"  // The snippet is never deleted
+	expiresCustom = "custom" // The snippet expires at ExpiresAt
+	expiresKeep   = "keep"   // The snippet being edited keeps its expiry
+)
+
+// Layout of the value of datetime-local inputs
+const dateTimeLocalLayout = "2006-01-02T15:04"
+
+// Runs the validation checks shared by creating and editing a snippet.
+// If a check is false, then will add the error info the the form errors
+func (form *snippetCreateForm) validate() {
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	form.CheckField(validator.PermittedValue(form.Expires, "1", "7", "365", expiresNever, expiresCustom, expiresKeep), "expires", "This field must equal 1, 7, 365, never or custom")
+	if form.Expires == expiresKeep {
+		form.CheckField(form.Editing, "expires", "Only existing snippets can keep their expiry")
+	}
+	if form.Expires == expiresCustom {
+		expiresAt, err := time.ParseInLocation(dateTimeLocalLayout, form.ExpiresAt, time.UTC)
+		form.CheckField(err == nil, "expiresAt", "This field must be a valid date and time")
+		form.CheckField(err != nil || expiresAt.After(time.Now()), "expiresAt", "This field must be in the future")
+	}
+	form.CheckField(validator.MaxItems(form.tagList(), 10), "tags", "A snippet can't have more than 10 tags")
+	form.CheckField(validator.AllMatch(form.tagList(), validator.TagRX), "tags", "Tags can only contain letters, numbers and the characters + . _ - and be at most 30 characters long")
+
+	// Any language chroma can highlight is allowed, not just the ones in the form
+	_, known := lookupLanguage(form.Language)
+	form.CheckField(form.Language == "" || known, "language", "This language isn't supported")
+	form.CheckField(validator.PermittedValue(form.Format, models.FormatCode, models.FormatMarkdown), "format", "This field must equal code or markdown")
+	form.CheckField(validator.PermittedValue(form.Visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate), "visibility", "This field must equal public, unlisted or private")
+	form.CheckField(validator.Between(form.MaxViews, 0, 1000), "maxViews", "This field must be between 0 and 1000")
+
+	// Every file in a bundle needs a name of its own, the snippet's content is
+	// saved under a name made from its title
+	form.CheckField(len(form.Files) < maxFiles, "files", fmt.Sprintf("A snippet can't have more than %d files", maxFiles))
+	names := map[string]bool{snippetFilename(form.snippet()): true}
+	for i, file := range form.Files {
+		key := fmt.Sprintf("files[%d]", i)
+		form.CheckField(validator.NotBlank(file.Name), key+".name", "This field cannot be blank")
+		form.CheckField(validator.MaxChars(file.Name, 100), key+".name", "This field cannot be more than 100 characters long")
+		form.CheckField(validator.Matches(file.Name, validator.FilenameRX), key+".name", "File names can only contain letters, numbers, spaces and the characters + . _ -")
+		form.CheckField(!names[file.Name], key+".name", "Another file already has this name")
+		names[file.Name] = true
+
+		_, known := lookupLanguage(file.Language)
+		form.CheckField(file.Language == "" || known, key+".language", "This language isn't supported")
+		form.CheckField(validator.NotBlank(file.Content), key+".content", "This field cannot be blank")
+	}
+}
+
+// Splits the tags field into a list of lowercase tags without duplicates
+func (form *snippetCreateForm) tagList() []string {
+	fields := strings.FieldsFunc(strings.ToLower(form.Tags), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range fields {
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Works out when the snippet described by the form expires, nil meaning never.
+// current is the expiry of the snippet being edited. The form must be valid
+func (form *snippetCreateForm) expiry(current *time.Time) *time.Time {
+	var expires time.Time
+	switch form.Expires {
+	case expiresNever:
+		return nil
+	case expiresKeep:
+		return current
+	case expiresCustom:
+		expires, _ = time.ParseInLocation(dateTimeLocalLayout, form.ExpiresAt, time.UTC)
+	default:
+		days, _ := strconv.Atoi(form.Expires)
+		expires = time.Now().UTC().AddDate(0, 0, days)
+	}
+	return &expires
+}
+
+// Builds the snippet described by the form. If no language was picked, it is
+// detected from the content. The source of markdown snippets is always markdown.
+// Snippets with a view limit are never listed, so that nobody uses up their
+// views by browsing, and are made unlisted if they were public
+func (form *snippetCreateForm) snippet() *models.Snippet {
+	maxViews := form.MaxViews
+	if form.BurnAfterReading {
+		maxViews = 1
+	}
+	visibility := form.Visibility
+	if maxViews > 0 && visibility == models.VisibilityPublic {
+		visibility = models.VisibilityUnlisted
+	}
+
+	language, ok := lookupLanguage(form.Language)
+	if form.Format == models.FormatMarkdown {
+		language, _ = lookupLanguage("markdown")
+	} else if !ok {
+		language = detectLanguage(form.Content)
+	}
+
+	files := []*models.SnippetFile{}
+	for _, file := range form.Files {
+		language, ok := lookupLanguage(file.Language)
+		if !ok {
+			language = detectFileLanguage(file.Name, file.Content)
+		}
+		files = append(files, &models.SnippetFile{
+			Name:     file.Name,
+			Language: language,
+			Content:  file.Content,
+		})
+	}
+
+	return &models.Snippet{
+		Title:      form.Title,
+		Content:    form.Content,
+		Tags:       form.tagList(),
+		Language:   language,
+		Format:     form.Format,
+		Visibility: visibility,
+		MaxViews:   maxViews,
+		Files:      files,
+	}
+}
+
+// Returns the form fields for the extra files of a snippet
+func filesForm(files []*models.SnippetFile) []snippetFileForm {
+	forms := []snippetFileForm{}
+	for _, file := range files {
+		forms = append(forms, snippetFileForm{
+			Name:     file.Name,
+			Language: file.Language,
+			Content:  file.Content,
+		})
+	}
+	return forms
 }
 
 func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 
 	// Initialize data.Form along with any default form values
-	data.Form = snippetCreateForm{
-		Expires: 365,
+	form := snippetCreateForm{
+		Expires:    "365",
+		Format:     models.FormatCode,
+		Visibility: models.VisibilityPublic,
+	}
+
+	// Forking a snippet prefills the form with a copy of it
+	if ref := r.URL.Query().Get("fork"); ref != "" {
+		source, err := app.forkSource(r, ref)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w)
+			} else {
+				app.serverError(w, err)
+			}
+			return
+		}
+
+		form.Title = source.Title
+		form.Content = source.Content
+		form.Tags = strings.Join(source.Tags, ", ")
+		form.Language = source.Language
+		form.Format = source.Format
+		// Forks of unlisted and private snippets stay just as hidden by default
+		form.Visibility = source.Visibility
+		form.Files = filesForm(source.Files)
+		form.ForkedFrom = ref
+		form.ForkedTitle = source.Title
 	}
+
+	data.Form = form
 	app.render(w, http.StatusOK, "create.tmpl.html", data)
 }
 
+// Fetches the snippet to fork given its ref. Copying a view-limited snippet
+// would get around its limit, so only its author can fork it
+func (app *application) forkSource(r *http.Request, ref string) (*models.Snippet, error) {
+	source, err := app.snippetFromRef(r, ref)
+	if err != nil {
+		return nil, err
+	}
+	if source.MaxViews > 0 && !app.isAuthor(r, source) {
+		return nil, models.ErrNoRecord
+	}
+	return source, nil
+}
+
 func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
 	// Adds data in POST request bodies to the r.PostForm map
 	// Function also works for PUT and PATCH
@@ -128,11 +651,32 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// If the check is false, then will add the error info the the form errors
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
-	form.CheckField(validator.PermittedInt(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7, or 365")
+	// Keep track of where a fork came from. If the original has been deleted
+	// in the meantime, the fork is saved as a snippet of its own
+	var forkedFrom int
+	if form.ForkedFrom != "" {
+		source, err := app.forkSource(r, form.ForkedFrom)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+		if err == nil {
+			forkedFrom = source.ID
+			form.ForkedTitle = source.Title
+		} else {
+			form.ForkedFrom = ""
+		}
+	}
+
+	// Adding or removing a file just shows the changed form again
+	if form.applyAction() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusOK, "create.tmpl.html", data)
+		return
+	}
+
+	form.validate()
 
 	// Instead of only checking the length, use our Valid method to see if the form is valid
 	// If there's form errors, refill form data + reload and send a 422
@@ -143,18 +687,250 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Pass data to Insert method and receive ID of the inserted method back
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
-	if err != nil {
+	// Snippets are owned by the user who created them
+	snippet := form.snippet()
+	snippet.UserID = app.authenticatedUserID(r)
+
+	snippet.Expires = form.expiry(nil)
+	snippet.ForkedFrom = forkedFrom
+
+	// Pass data to Insert method, which fills in the snippet's ID and slug
+	_, err = app.snippets.Insert(snippet)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// If snippet is successfully added to DB, add key value pair to session data
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
+	// Redirect user to the new snippet's view page
+	// Use clean URL format
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", snippet.Ref()), http.StatusSeeOther)
+}
+
+func (app *application) snippetEdit(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+
+	// Prefill the form with the snippet's current values
+	form := snippetCreateForm{
+		Title:      snippet.Title,
+		Content:    snippet.Content,
+		Expires:    expiresKeep,
+		Editing:    true,
+		Tags:       strings.Join(snippet.Tags, ", "),
+		Language:   snippet.Language,
+		Format:     snippet.Format,
+		Visibility: snippet.Visibility,
+		Files:      filesForm(snippet.Files),
+	}
+	if snippet.Expires != nil {
+		form.ExpiresAt = snippet.Expires.Format(dateTimeLocalLayout)
+	}
+	if snippet.MaxViews == 1 {
+		form.BurnAfterReading = true
+	} else {
+		form.MaxViews = snippet.MaxViews
+	}
+	data.Form = form
+	app.render(w, http.StatusOK, "edit.tmpl.html", data)
+}
+
+func (app *application) snippetEditPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	var form snippetCreateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.Editing = true
+
+	// Adding or removing a file just shows the changed form again
+	if form.applyAction() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, http.StatusOK, "edit.tmpl.html", data)
+		return
+	}
+
+	// Edits go through the same checks as newly created snippets
+	form.validate()
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "edit.tmpl.html", data)
+		return
+	}
+
+	// The previous version is kept in the snippet's revision history
+	userID := app.authenticatedUserID(r)
+	updated := form.snippet()
+	updated.ID = snippet.ID
+	updated.Slug = snippet.Slug
+	updated.Expires = form.expiry(snippet.Expires)
+
+	err = app.snippets.Update(updated, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully updated!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", updated.Ref()), http.StatusSeeOther)
+}
+
+func (app *application) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(snippet.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully deleted!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (app *application) snippetHistory(w http.ResponseWriter, r *http.Request) {
+	snippet, err := app.snippetFromParams(r)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Old versions would show the content of a view limited snippet without
+	// counting the view, so only its author gets to see them
+	if snippet.MaxViews > 0 && !app.isAuthor(r, snippet) {
+		app.notFound(w)
+		return
+	}
+
+	revisions, err := app.snippets.Revisions(snippet.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Revisions = revisions
+
+	app.render(w, http.StatusOK, "history.tmpl.html", data)
+}
+
+func (app *application) snippetDiff(w http.ResponseWriter, r *http.Request) {
+	snippet, err := app.snippetFromParams(r)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Old versions would show the content of a view limited snippet without
+	// counting the view, so only its author gets to see them
+	if snippet.MaxViews > 0 && !app.isAuthor(r, snippet) {
+		app.notFound(w)
+		return
+	}
+
+	revisions, err := app.snippets.Revisions(snippet.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if len(revisions) == 0 {
+		app.notFound(w)
+		return
+	}
+
+	// Compare the versions named in the query string. Without them, show what
+	// changed in the latest version
+	to := revisions[0].Version
+	if v := r.URL.Query().Get("to"); v != "" {
+		to, err = strconv.Atoi(v)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	from := to - 1
+	if v := r.URL.Query().Get("from"); v != "" {
+		from, err = strconv.Atoi(v)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Versions are numbered from 1, so a diff of the first version shows
+	// everything as added
+	fromRevision := &models.Revision{SnippetID: snippet.ID}
+	if from > 0 {
+		fromRevision, err = app.snippets.Revision(snippet.ID, from)
+	}
+	var toRevision *models.Revision
+	if err == nil {
+		toRevision, err = app.snippets.Revision(snippet.ID, to)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Huge versions aren't compared, since that could take a long time
+	hunks, err := diff.Unified(fromRevision.Content, toRevision.Content, 3)
+	if err != nil && !errors.Is(err, diff.ErrTooLarge) {
 		app.serverError(w, err)
 		return
 	}
 
-	// If snippet is successfully added to DB, add key value pair to session data
-	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
-	// Redirect user to the new snippet's view page
-	// Use clean URL format
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Revisions = revisions
+	data.Diff = &revisionDiff{
+		From:     fromRevision,
+		To:       toRevision,
+		Hunks:    hunks,
+		TooLarge: errors.Is(err, diff.ErrTooLarge),
+	}
+
+	app.render(w, http.StatusOK, "diff.tmpl.html", data)
 }
 
 // Struct for holding form data in template
@@ -198,13 +974,13 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 
 	// Attempt to create new user record in the database. If email already exists
 	// then rerender the page with the error.
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	id, err := app.users.Insert(form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.AddFieldError("email", "Email address is already in use")
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.html.tmpl", data)
+			app.render(w, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
 		} else {
 			// If the error is some other type, then throw a server error
 			app.serverError(w, err)
@@ -212,11 +988,125 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The user can't log in until they follow the link sent to their address
+	app.sendVerification(id, form.Name, form.Email)
+
 	// If user was created with no errors then add flash message to the session
-	// confirming that it went through. Will be displayed on the login page
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
+	// confirming that it went through. Will be displayed on the verify page
+	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Check your email for a link to verify your address.")
+
+	// Redirect to the page for getting a new link
+	http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+}
+
+// How long an emailed verification link works for
+const verificationTTL = 24 * time.Hour
+
+// Emails the user a signed link that verifies their address
+func (app *application) sendVerification(id int, name, email string) {
+	token := app.signToken("verify", fmt.Sprintf("%d:%s", id, email), verificationTTL)
+	app.sendMail(email, "verify.tmpl", map[string]any{
+		"Name":  name,
+		"URL":   app.baseURL + "/user/verify/" + token,
+		"Hours": int(verificationTTL.Hours()),
+	})
+}
+
+type userVerifyForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+// Page shown after signing up, where users can ask for a new verification link
+func (app *application) userVerify(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userVerifyForm{}
+	app.render(w, http.StatusOK, "verify.tmpl.html", data)
+}
+
+// Sends a new verification link. The response is the same whether or not there's
+// an unverified account with the address, so it can't be used to find out who
+// has an account
+func (app *application) userVerifyPost(w http.ResponseWriter, r *http.Request) {
+	var form userVerifyForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "verify.tmpl.html", data)
+		return
+	}
+
+	// Don't let the form be used to flood someone's inbox
+	ok, _ := app.mailLimiter.allow(ipaddr.Network(clientIP(r)))
+	if !ok {
+		form.AddNonFieldError("Too many emails have been asked for, please try again in a minute")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusTooManyRequests, "verify.tmpl.html", data)
+		return
+	}
+
+	user, err := app.users.GetByEmail(form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+	if err == nil && !user.Verified && !user.Disabled {
+		app.sendVerification(user.ID, user.Name, user.Email)
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "If that address belongs to an account that still needs verifying, a new link is on its way.")
+	http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+}
+
+// Follows the link in a verification email
+func (app *application) userVerifyToken(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	// Links that have been tampered with, have expired or are for an address
+	// the user no longer has all get the same page
+	invalidLink := func() {
+		form := userVerifyForm{}
+		form.AddNonFieldError("This link is invalid or has expired. Enter your email address to get a new one.")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusBadRequest, "verify.tmpl.html", data)
+	}
+
+	// The token holds the ID and address of the user, signed by signToken
+	value, err := app.parseSignedToken("verify", params.ByName("token"))
+	if err != nil {
+		invalidLink()
+		return
+	}
+	idString, email, _ := strings.Cut(value, ":")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		invalidLink()
+		return
+	}
+
+	err = app.users.Verify(id, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			invalidLink()
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
 
-	// Redirect to the login page
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified. Please log in.")
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
@@ -253,33 +1143,110 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		data := app.newTemplateData(r)
 		data.Form = form
 		app.render(w, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		return
+	}
+
+	// Count the attempt as a failure before checking the password, so parallel
+	// attempts can't get past the limits. Logins are refused while the email or
+	// IP address is blocked for failing too often, without even checking the
+	// password. Email addresses without an account get blocked the same way,
+	// so this doesn't give away which exist
+	attempt, wait, err := app.attemptLogin(form.Email, ipaddr.Network(clientIP(r)))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if attempt == nil {
+		form.AddNonFieldError(fmt.Sprintf("Too many failed login attempts. Please try again in %s.", humanDuration(wait)))
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusTooManyRequests, "login.tmpl.html", data)
+		return
 	}
 
 	// Check if credentials are valid. If invalid then add generic non-field error message
 	// and rerender the login page
 	id, err := app.users.Authenticate(form.Email, form.Password)
-	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		if attempt.Wait > 0 {
+			form.AddNonFieldError(fmt.Sprintf("Email or password is incorrect. Too many failed login attempts, please try again in %s.", humanDuration(attempt.Wait)))
+		} else {
 			form.AddNonFieldError("Email or password is incorrect")
+		}
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		return
+	}
+
+	if err != nil {
+		// The password was right, the account just can't be used yet
+		if errors.Is(err, models.ErrDisabledUser) || errors.Is(err, models.ErrUnverifiedUser) {
+			succeededErr := app.loginSucceeded(attempt, true)
+			if succeededErr != nil {
+				app.serverError(w, succeededErr)
+				return
+			}
+		}
+
+		if errors.Is(err, models.ErrDisabledUser) {
+			form.AddNonFieldError("This account has been disabled")
 
 			data := app.newTemplateData(r)
 			data.Form = form
 			app.render(w, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		} else if errors.Is(err, models.ErrUnverifiedUser) {
+			// Offer to send a new link, since the first one may have expired
+			verifyForm := userVerifyForm{Email: form.Email}
+			verifyForm.AddNonFieldError("You need to verify your email address before you can log in. Use the link we emailed you, or get a new one below.")
+
+			data := app.newTemplateData(r)
+			data.Form = verifyForm
+			app.render(w, http.StatusForbidden, "verify.tmpl.html", data)
 		} else {
 			app.serverError(w, err)
 		}
 		return
 	}
 
+	// Users with two-factor authentication still need to enter a code, so they
+	// aren't logged in yet
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.loginSucceeded(attempt, !user.TwoFactor)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if user.TwoFactor {
+		app.startTwoFactorLogin(w, r, user)
+		return
+	}
+
+	app.logIn(w, r, id)
+}
+
+// Logs the user in once they've proven who they are, and sends them on to the
+// page they were trying to get to
+func (app *application) logIn(w http.ResponseWriter, r *http.Request, id int) {
 	// Renew token to change the session ID on login
 	// Retains the session data but creates a new ID
-	err = app.sessionManager.RenewToken(r.Context())
+	err := app.sessionManager.RenewToken(r.Context())
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	err = app.putLoggedIn(r, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
 	// If user was attempting to access a protected page and was redirected, then
 	// redirect to that page. Otherwise redirect the user to the create snippet page
@@ -292,6 +1259,19 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 }
 
+// Remembers the user as logged in to the session. The session stops counting
+// once the user is logged out everywhere
+func (app *application) putLoggedIn(r *http.Request, id int) error {
+	user, err := app.users.Get(id)
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	app.sessionManager.Put(r.Context(), "sessionGeneration", user.SessionGeneration)
+	return nil
+}
+
 func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 	// Renew session token ID on logout
 	err := app.sessionManager.RenewToken(r.Context())
@@ -333,11 +1313,7 @@ func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http
 		return
 	}
 	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
-	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "This field must be at least 8 characters long")
-	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.NewPasswordConfirmation, 8), "newPasswordConfirmation", "This field must be at least 8 characters long")
-	form.CheckField(validator.MatchesString(form.NewPassword, form.NewPasswordConfirmation), "newPasswordConfirmation", "Passwords must match")
+	checkNewPassword(&form.Validator, form.NewPassword, form.NewPasswordConfirmation)
 
 	// If form is invalid, reload signup form with defaults
 	if !form.Valid() {
@@ -348,13 +1324,234 @@ func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http
 		return
 	}
 
-	id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	id := app.authenticatedUserID(r)
 
-	err = app.users.PasswordUpdate(id, form.CurrentPassword, form.NewPassword)
-	if errors.Is(err, models.ErrInvalidCredentials) {
-		app.sessionManager.Put(r.Context(), "flash", "Incorrect password.")
+	// The current password is checked under the login limits, so a stolen
+	// session can't be used to guess it
+	wait, err := app.checkPassword(r, id, form.CurrentPassword)
+	if err != nil {
+		switch {
+		case errors.Is(err, errLoginBlocked):
+			form.AddFieldError("currentPassword", fmt.Sprintf("Too many failed attempts. Please try again in %s.", humanDuration(wait)))
+		case errors.Is(err, models.ErrInvalidCredentials) && wait > 0:
+			form.AddFieldError("currentPassword", fmt.Sprintf("Current password is incorrect. Too many failed attempts, please try again in %s.", humanDuration(wait)))
+		case errors.Is(err, models.ErrInvalidCredentials):
+			form.AddFieldError("currentPassword", "Current password is incorrect")
+		default:
+			app.serverError(w, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "password.tmpl.html", data)
+		return
+	}
+
+	err = app.users.UpdateHashedPassword(id, form.NewPassword)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Like a reset, a change ends every other session and revokes the API
+	// tokens, in case someone else had the old password or a session. This
+	// session is logged back in under the new generation
+	err = app.logOutEverywhere(r, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.putLoggedIn(r, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
 	}
-	app.sessionManager.Put(r.Context(), "flash", "Your password has been changed successfully!")
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been changed and you've been logged out everywhere else, API tokens included.")
 
 	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
 }
+
+// Checks the newPassword and newPasswordConfirmation fields shared by the forms
+// for changing and resetting passwords
+func checkNewPassword(v *validator.Validator, password, confirmation string) {
+	v.CheckField(validator.NotBlank(password), "newPassword", "This field cannot be blank")
+	v.CheckField(validator.MinChars(password, 8), "newPassword", "This field must be at least 8 characters long")
+	v.CheckField(validator.NotBlank(confirmation), "newPasswordConfirmation", "This field cannot be blank")
+	v.CheckField(validator.MinChars(confirmation, 8), "newPasswordConfirmation", "This field must be at least 8 characters long")
+	v.CheckField(validator.MatchesString(password, confirmation), "newPasswordConfirmation", "Passwords must match")
+}
+
+// How long an emailed password reset link works for
+const passwordResetTTL = time.Hour
+
+type passwordForgotForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) passwordForgot(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = passwordForgotForm{}
+	app.render(w, http.StatusOK, "forgot.tmpl.html", data)
+}
+
+// Emails a password reset link. The response is the same whether or not there's
+// an account with the address, so it can't be used to find out who has an account
+func (app *application) passwordForgotPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordForgotForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "forgot.tmpl.html", data)
+		return
+	}
+
+	// Don't let the form be used to flood someone's inbox
+	ok, _ := app.mailLimiter.allow(ipaddr.Network(clientIP(r)))
+	if !ok {
+		form.AddNonFieldError("Too many emails have been asked for, please try again in a minute")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusTooManyRequests, "forgot.tmpl.html", data)
+		return
+	}
+
+	// Looking the account up and saving a token take longer than finding no
+	// account, so all of it happens after the response is sent. Otherwise the
+	// response time would give away which addresses have an account
+	email := form.Email
+	app.background(func() {
+		user, err := app.users.GetByEmail(email)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.errorLog.Print(err)
+			}
+			return
+		}
+		if user.Disabled {
+			return
+		}
+		// The IP limit above doesn't stop requests spread over many
+		// addresses from flooding one inbox. Links over this limit are
+		// dropped quietly, telling the user would give the account away
+		ok, _ := app.inboxLimiter.allow(strings.ToLower(strings.TrimSpace(user.Email)))
+		if !ok {
+			return
+		}
+
+		token, err := app.users.CreatePasswordReset(user.ID, passwordResetTTL)
+		if err != nil {
+			app.errorLog.Print(err)
+			return
+		}
+		app.sendMail(user.Email, "reset.tmpl", map[string]any{
+			"Name":    user.Name,
+			"URL":     app.baseURL + "/user/password/reset/" + token,
+			"Minutes": int(passwordResetTTL.Minutes()),
+		})
+	})
+
+	app.sessionManager.Put(r.Context(), "flash", "If there's an account with that address, we've emailed it a link to reset the password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+type passwordResetForm struct {
+	// Reset token from the emailed link, for the form to post back to
+	Token                   string `form:"-"`
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator     `form:"-"`
+}
+
+// Shows the page for links that can't be used to reset a password
+func (app *application) invalidPasswordReset(w http.ResponseWriter, r *http.Request) {
+	form := passwordForgotForm{}
+	form.AddNonFieldError("This link is invalid or has expired. Enter your email address to get a new one.")
+	data := app.newTemplateData(r)
+	data.Form = form
+	app.render(w, http.StatusBadRequest, "forgot.tmpl.html", data)
+}
+
+func (app *application) passwordReset(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	token := params.ByName("token")
+
+	// Check the link before asking for a new password
+	_, err := app.users.CheckPasswordReset(token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.invalidPasswordReset(w, r)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = passwordResetForm{Token: token}
+	app.render(w, http.StatusOK, "reset.tmpl.html", data)
+}
+
+func (app *application) passwordResetPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	form := passwordResetForm{Token: params.ByName("token")}
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	checkNewPassword(&form.Validator, form.NewPassword, form.NewPasswordConfirmation)
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "reset.tmpl.html", data)
+		return
+	}
+
+	// Using the token up and setting the password happen together, so a link
+	// can only ever be used once
+	id, err := app.users.ResetPassword(form.Token, form.NewPassword)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.invalidPasswordReset(w, r)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Whoever knew the old password is logged out and loses any API tokens
+	// they made with it, and the owner doesn't have to wait out the failed
+	// logins that sent them here
+	err = app.logOutEverywhere(r, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	_, err = app.loginFailures.Clear(models.LoginFailureEmail, user.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset and you have been logged out everywhere, API tokens included. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
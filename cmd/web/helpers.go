@@ -4,11 +4,22 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/dwang288/snippetbox/internal/models"
+	"github.com/dwang288/snippetbox/internal/validator"
+
 	"github.com/go-playground/form/v4"
+	"github.com/julienschmidt/httprouter"
 )
 
 // On error, logs the error trace and writes the status text for internal server error
@@ -70,8 +81,18 @@ func (app *application) newTemplateData(r *http.Request) *templateData {
 		CurrentYear: time.Now().Year(),
 		Flash:       app.sessionManager.PopString(r.Context(), "flash"),
 		// Add authentication status to the template data
-		IsAuthenticated: app.isAuthenticated(r),
+		IsAuthenticated:     app.isAuthenticated(r),
+		AuthenticatedUserID: app.authenticatedUserID(r),
+		SSOName:             app.ssoName(),
+	}
+}
+
+// Returns the name of the single sign-on provider, or nothing if there isn't one
+func (app *application) ssoName() string {
+	if app.oidc == nil {
+		return ""
 	}
+	return app.oidc.name
 }
 
 // Decode request body into target dst
@@ -103,3 +124,271 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// Returns true if the request is from the authenticated user who created the
+// snippet. Snippets without an author don't belong to anyone
+func (app *application) isAuthor(r *http.Request, snippet *models.Snippet) bool {
+	id := app.authenticatedUserID(r)
+	return id != 0 && snippet.UserID == id
+}
+
+// Returns the ID of the authenticated user, or 0 if the request isn't authenticated
+func (app *application) authenticatedUserID(r *http.Request) int {
+	if !app.isAuthenticated(r) {
+		return 0
+	}
+	id, _ := r.Context().Value(authenticatedUserIDContextKey).(int)
+	return id
+}
+
+// Returns the IP address of the client, without its port. The login throttle
+// and the paste and mail limiters all key on this, so it deliberately ignores
+// X-Forwarded-For and similar headers: any client can set them, and trusting
+// them would let one address pose as many. The flip side is that behind a
+// reverse proxy every client shares the proxy's address, and so its limits
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Logs the user out everywhere, including logins waiting for a two-factor
+// code, and revokes their API tokens. For when someone else may have had the
+// password
+func (app *application) logOutEverywhere(r *http.Request, id int) error {
+	err := app.users.LogOutEverywhere(id)
+	if err != nil {
+		return err
+	}
+	_, err = app.tokens.DeleteByUser(id)
+	if err != nil {
+		return err
+	}
+
+	// The request's own session no longer counts either, but it's given a new
+	// token and forgets the user so it doesn't look logged in to this request
+	if app.sessionManager.GetInt(r.Context(), "authenticatedUserID") == id || app.sessionManager.GetInt(r.Context(), "twoFactorUserID") == id {
+		err = app.sessionManager.RenewToken(r.Context())
+		if err != nil {
+			return err
+		}
+		app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+		app.endTwoFactorLogin(r)
+	}
+	return nil
+}
+
+// Fetches the snippet named by the :id parameter in the request URL, which is
+// either the ID of a public snippet or the slug of any snippet. Returns
+// models.ErrNoRecord if there's no such snippet or the user isn't allowed to see it
+func (app *application) snippetFromParams(r *http.Request) (*models.Snippet, error) {
+	// Grab named parameters from request with ParamsFromContext(r.Context())
+	params := httprouter.ParamsFromContext(r.Context())
+	return app.snippetFromRef(r, params.ByName("id"))
+}
+
+// Fetches the snippet with the given ref, which is either the ID of a public
+// snippet or the slug of any snippet. Returns models.ErrNoRecord if there's no
+// such snippet or the user isn't allowed to see it
+func (app *application) snippetFromRef(r *http.Request, ref string) (*models.Snippet, error) {
+	// Try to turn the ref into an int. If that doesn't work, it's a slug
+	var snippet *models.Snippet
+	id, err := strconv.Atoi(ref)
+	byID := err == nil
+	if byID {
+		// If it's out of the expected range then there's no snippet to find
+		if id < 1 {
+			return nil, models.ErrNoRecord
+		}
+		snippet, err = app.snippets.Get(id)
+	} else {
+		snippet, err = app.snippets.GetBySlug(ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !canSeeSnippet(snippet, app.authenticatedUserID(r), byID) {
+		return nil, models.ErrNoRecord
+	}
+
+	return snippet, nil
+}
+
+// Reports whether the user, or nobody if userID is 0, can see the snippet when
+// it's looked up by its ID or by its slug. Authors can always see their own
+// snippets. Anyone else needs the slug to find an unlisted snippet and can't
+// see private snippets at all
+func canSeeSnippet(snippet *models.Snippet, userID int, byID bool) bool {
+	if userID != 0 && snippet.UserID == userID {
+		return true
+	}
+	switch snippet.Visibility {
+	case models.VisibilityPublic:
+		return true
+	case models.VisibilityUnlisted:
+		return !byID
+	default:
+		return false
+	}
+}
+
+// Returned when a user tries to change a snippet they aren't the author of
+var errNotAuthor = errors.New("not the author of the snippet")
+
+// Fetches the snippet named in the request URL for someone to read, and counts
+// the view if the snippet has a view limit and the reader isn't its author.
+// Returns the number of views left, or -1 if none were counted. If the snippet
+// can't be shown, an error response is written and ok is false
+func (app *application) viewSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, int, bool) {
+	snippet, remainingViews, err := app.readSnippet(w, r)
+	if err != nil {
+		app.snippetError(w, err)
+		return nil, 0, false
+	}
+	return snippet, remainingViews, true
+}
+
+// Does the work of viewSnippet, but leaves writing an error response to the
+// caller. Returns models.ErrNoRecord if the snippet can't be shown
+func (app *application) readSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, int, error) {
+	// Retrieve the snippet data from the db with the id in the URL
+	snippet, err := app.snippetFromParams(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Keep unlisted and private snippets out of shared caches
+	if snippet.Visibility != models.VisibilityPublic || snippet.MaxViews > 0 {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	// Count the view if the snippet has a view limit and this isn't its author.
+	// If someone else used up the last view first, the snippet is already gone
+	remainingViews := -1
+	if snippet.MaxViews > 0 && !app.isAuthor(r, snippet) {
+		remainingViews, err = app.snippets.RecordView(snippet.ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		snippet.Views = snippet.MaxViews - remainingViews
+	}
+
+	return snippet, remainingViews, nil
+}
+
+// Writes the content of a snippet as a plain text file. The disposition is
+// either inline, to show it in the browser, or attachment, to download it
+func (app *application) writeSnippetFile(w http.ResponseWriter, snippet *models.Snippet, disposition string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// FormatMediaType quotes the filename, and encodes it if it isn't ASCII
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{
+		"filename": snippetFilename(snippet),
+	}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(snippet.Content)))
+
+	io.WriteString(w, snippet.Content)
+}
+
+// Returns the name to save a snippet's content under, made from its title and
+// the usual file extension of its language, e.g. "Nginx config!" in Nginx
+// becomes nginx-config.conf
+func snippetFilename(snippet *models.Snippet) string {
+	name := strings.Trim(nonSlugRX.ReplaceAllString(strings.ToLower(snippet.Title), "-"), "-.")
+	if name == "" {
+		name = "snippet"
+	}
+	return name + fileExtension(snippet.Language)
+}
+
+// Runs of characters that aren't allowed in a snippet's filename
+var nonSlugRX = regexp.MustCompile(`[^\p{L}\p{N}_.]+`)
+
+// Fetches the snippet named in the request URL and checks that the authenticated
+// user is its author. If not, an error response is written and ok is false
+func (app *application) ownedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	snippet, err := app.authoredSnippet(r)
+	if err != nil {
+		app.snippetError(w, err)
+		return nil, false
+	}
+	return snippet, true
+}
+
+// Does the work of ownedSnippet, but leaves writing an error response to the
+// caller. Returns errNotAuthor if the user isn't the snippet's author
+func (app *application) authoredSnippet(r *http.Request) (*models.Snippet, error) {
+	snippet, err := app.snippetFromParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the author of a snippet is allowed to change it
+	if !app.isAuthor(r, snippet) {
+		return nil, errNotAuthor
+	}
+
+	return snippet, nil
+}
+
+// Writes the error response for an error returned while fetching a snippet
+func (app *application) snippetError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		app.notFound(w)
+	case errors.Is(err, errNotAuthor):
+		app.clientError(w, http.StatusForbidden)
+	default:
+		app.serverError(w, err)
+	}
+}
+
+// Reads the sort order and pagination cursors of a snippet listing from the
+// query string. Returns an error if a cursor can't be read
+func listOptions(r *http.Request) (models.ListOptions, error) {
+	// Unknown sort orders fall back to newest first
+	query := r.URL.Query()
+	opts := models.ListOptions{
+		Sort:  query.Get("sort"),
+		Limit: pageSize,
+	}
+	if !validator.PermittedValue(opts.Sort, models.SortCreated, models.SortExpires) {
+		opts.Sort = models.SortCreated
+	}
+
+	// The cursors are the ones pageCursorURL hands out, anything else is a bad
+	// request
+	for key, dst := range map[string]**models.Cursor{"after": &opts.After, "before": &opts.Before} {
+		v := query.Get(key)
+		if v == "" {
+			continue
+		}
+		cursor, err := models.ParseCursor(v)
+		if err != nil {
+			return opts, err
+		}
+		*dst = &cursor
+	}
+	return opts, nil
+}
+
+// Returns the URL of the page of a listing on the given side, "after" or
+// "before", of a snippet
+func pageCursorURL(r *http.Request, side, sort string, snippet *models.Snippet) string {
+	return pageURL(r, side, models.CursorFor(sort, snippet).String())
+}
+
+// Returns the URL of the current page with its pagination parameters replaced by
+// key=value. Any other query parameters, like the sort order, are kept
+func pageURL(r *http.Request, key string, value string) string {
+	query := r.URL.Query()
+	for _, k := range []string{"after", "before", "page"} {
+		query.Del(k)
+	}
+	query.Set(key, value)
+
+	u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return u.String()
+}
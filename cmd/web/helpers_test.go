@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dwang288/snippetbox/internal/models"
+)
+
+func TestCanSeeSnippet(t *testing.T) {
+	const author, other = 1, 2
+
+	tests := []struct {
+		name       string
+		visibility string
+		owner      int
+		userID     int
+		byID       bool
+		want       bool
+	}{
+		{name: "Public by ID", visibility: models.VisibilityPublic, owner: author, userID: other, byID: true, want: true},
+		{name: "Public by slug", visibility: models.VisibilityPublic, owner: author, userID: other, want: true},
+		{name: "Public to anonymous", visibility: models.VisibilityPublic, owner: author, byID: true, want: true},
+		{name: "Unlisted by ID", visibility: models.VisibilityUnlisted, owner: author, userID: other, byID: true, want: false},
+		{name: "Unlisted by slug", visibility: models.VisibilityUnlisted, owner: author, userID: other, want: true},
+		{name: "Unlisted by ID to the author", visibility: models.VisibilityUnlisted, owner: author, userID: author, byID: true, want: true},
+		{name: "Private by slug", visibility: models.VisibilityPrivate, owner: author, userID: other, want: false},
+		{name: "Private to anonymous", visibility: models.VisibilityPrivate, owner: author, want: false},
+		{name: "Private to the author", visibility: models.VisibilityPrivate, owner: author, userID: author, byID: true, want: true},
+		{name: "Anonymous snippet to anonymous", visibility: models.VisibilityPrivate, owner: 0, userID: 0, want: false},
+		{name: "Unknown visibility", visibility: "secret", owner: author, userID: other, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippet := &models.Snippet{UserID: tt.owner, Visibility: tt.visibility}
+			got := canSeeSnippet(snippet, tt.userID, tt.byID)
+			if got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantSort   string
+		wantAfter  string
+		wantBefore string
+		wantErr    bool
+	}{
+		{name: "Defaults", query: "", wantSort: models.SortCreated},
+		{name: "Unknown sort", query: "sort=title", wantSort: models.SortCreated},
+		{name: "After", query: "sort=expires&after=1709296200_7", wantSort: models.SortExpires, wantAfter: "1709296200_7"},
+		{name: "Before", query: "before=1709296200_7", wantSort: models.SortCreated, wantBefore: "1709296200_7"},
+		{name: "Bare snippet ID", query: "after=7", wantErr: true},
+		{name: "Bad cursor", query: "before=x_7", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/snippets?"+tt.query, nil)
+			opts, err := listOptions(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cursor := func(c *models.Cursor) string {
+				if c == nil {
+					return ""
+				}
+				return c.String()
+			}
+			if opts.Sort != tt.wantSort || cursor(opts.After) != tt.wantAfter || cursor(opts.Before) != tt.wantBefore {
+				t.Errorf("got sort %q, after %q, before %q", opts.Sort, cursor(opts.After), cursor(opts.Before))
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"path/filepath"
+	"strings"
+
+	"github.com/dwang288/snippetbox/internal/validator"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// Languages offered in the snippet form, by their chroma lexer name. Snippets
+// can still use any other language chroma knows, e.g. one that was detected
+var languages = []string{
+	"Bash", "C", "C++", "C#", "CSS", "Diff", "Docker", "Go", "HCL", "HTML", "INI",
+	"Java", "JavaScript", "JSON", "Kotlin", "Lua", "Makefile", "Nginx configuration file",
+	"PHP", "PowerShell", "Python", "Ruby", "Rust", "SQL", "TOML", "TypeScript", "XML", "YAML",
+}
+
+// Formatter used for all highlighted code. It only emits CSS classes and never
+// inline styles, since the Content-Security-Policy set in secureHeaders would
+// block them. The classes are styled by ui/static/css/chroma.css, which has to
+// be regenerated from highlightStyle whenever chroma is upgraded
+var highlightFormatter = html.New(
+	html.WithClasses(true),
+	html.WithLineNumbers(true),
+	html.TabWidth(4),
+)
+
+// Style the chroma.css stylesheet was generated from
+const highlightStyle = "github"
+
+// Returns the chroma name of a language if chroma knows about it. The name can
+// be any of chroma's names or aliases for the language, ignoring case
+func lookupLanguage(name string) (string, bool) {
+	lexer := lexers.Get(name)
+	if lexer == nil {
+		return "", false
+	}
+	return lexer.Config().Name, true
+}
+
+// Guesses the language of a piece of code from its content. Returns an empty
+// string when it can't tell, in which case the code is shown as plain text
+func detectLanguage(content string) string {
+	lexer := lexers.Analyse(content)
+	if lexer == nil {
+		return ""
+	}
+	return lexer.Config().Name
+}
+
+// Guesses the language of a file from its name, or its content if the name
+// doesn't give it away. Returns an empty string when it can't tell
+func detectFileLanguage(name, content string) string {
+	lexer := lexers.Match(name)
+	if lexer == nil {
+		return detectLanguage(content)
+	}
+	return lexer.Config().Name
+}
+
+// Returns the usual file extension of a language, including the dot, taken from
+// the filename patterns chroma matches the language by. Falls back to .txt for
+// plain text and languages that aren't recognised by their extension
+func fileExtension(language string) string {
+	lexer := lexers.Get(language)
+	if lexer == nil || language == "" {
+		return ".txt"
+	}
+	// Prefer patterns like *.go, then fall back to the extension of a full
+	// filename like nginx.conf. Patterns with more wildcards, like *.[ch], are
+	// skipped
+	filenames := lexer.Config().Filenames
+	for _, pattern := range filenames {
+		ext, ok := strings.CutPrefix(pattern, "*.")
+		if ok && ext != "" && !strings.ContainsAny(ext, "*?[") {
+			return "." + ext
+		}
+	}
+	for _, pattern := range filenames {
+		ext := filepath.Ext(pattern)
+		if ext != "" && !strings.ContainsAny(pattern, "*?[") {
+			return ext
+		}
+	}
+	return ".txt"
+}
+
+// Renders code as syntax highlighted HTML with line numbers. Unknown or empty
+// languages are rendered as plain text
+func highlight(content, language string) (template.HTML, error) {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	// Merge runs of tokens of the same type to keep the generated HTML small
+	lexer = chroma.Coalesce(lexer)
+
+	iterator, err := lexer.Tokenise(nil, content)
+	if err != nil {
+		return "", err
+	}
+
+	buf := new(bytes.Buffer)
+	err = highlightFormatter.Format(buf, styles.Get(highlightStyle), iterator)
+	if err != nil {
+		return "", err
+	}
+
+	// The formatter escapes the code itself, so the result is safe to use as HTML
+	return template.HTML(buf.String()), nil
+}
+
+// Returns the languages to offer in the snippet form. The selected language is
+// included even if it isn't one of the usual ones so that it stays selected
+func languageOptions(selected string) []string {
+	if selected == "" || validator.PermittedValue(selected, languages...) {
+		return languages
+	}
+	return append([]string{selected}, languages...)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"text/template"
+
+	"github.com/dwang288/snippetbox/internal/mailer"
+)
+
+// Email templates are plain text. Each one defines a "subject" and a "body"
+// template
+func newMailTemplateCache() (map[string]*template.Template, error) {
+	cache := map[string]*template.Template{}
+
+	files, err := filepath.Glob("./ui/mail/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		name := filepath.Base(file)
+		ts, err := template.ParseFiles(file)
+		if err != nil {
+			return nil, err
+		}
+		cache[name] = ts
+	}
+
+	return cache, nil
+}
+
+// Renders the email template and sends it in the background, so that a slow
+// mail server doesn't hold up the response. Failures are only logged
+func (app *application) sendMail(to, name string, data any) {
+	ts, ok := app.mailTemplates[name]
+	if !ok {
+		app.errorLog.Printf("the mail template %s does not exist", name)
+		return
+	}
+
+	subject := new(bytes.Buffer)
+	err := ts.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		app.errorLog.Print(err)
+		return
+	}
+	body := new(bytes.Buffer)
+	err = ts.ExecuteTemplate(body, "body", data)
+	if err != nil {
+		app.errorLog.Print(err)
+		return
+	}
+
+	msg := &mailer.Message{To: to, Subject: subject.String(), Body: body.String()}
+	app.background(func() {
+		err := app.mailer.Send(msg)
+		if err != nil {
+			app.errorLog.Printf("sending %s to %s: %v", name, to, err)
+		}
+	})
+}
+
+// Runs fn in a goroutine that the server waits for before it exits. Panics are
+// logged instead of taking the whole server down
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.errorLog.Print(fmt.Errorf("%s", err))
+			}
+		}()
+
+		fn()
+	}()
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	texttemplate "text/template"
 	"time"
 
+	"github.com/dwang288/snippetbox/internal/mailer"
 	"github.com/dwang288/snippetbox/internal/models"
 
 	"github.com/alexedwards/scs/mysqlstore"
@@ -28,7 +38,32 @@ type application struct {
 	// inject our model (db) into our application struct
 	snippets *models.SnippetModel
 	// inject our users model (db) into our application struct
-	users *models.UserModel
+	users models.UserModelInterface
+	// personal API tokens of the users
+	tokens *models.TokenModel
+	// failed logins, for slowing down password guessing
+	loginFailures *models.LoginFailureModel
+	// the sessions table behind sessionManager, for logging users out
+	sessions *models.SessionModel
+	// limits how often anonymous users can paste from the command line
+	pasteLimiter *rateLimiter
+	// limits how often an IP address can ask for emails to be sent
+	mailLimiter *rateLimiter
+	// limits how often password reset links are sent to the same address,
+	// however many IP addresses they're asked for from
+	inboxLimiter *rateLimiter
+	// sends emails like verification links
+	mailer        mailer.Mailer
+	mailTemplates map[string]*texttemplate.Template
+	// URL the server is reached at, used for links in emails
+	baseURL string
+	// key for signing the tokens in emailed links
+	secretKey []byte
+	// single sign-on through an OpenID Connect provider, nil if not set up
+	oidc *oidcLogin
+	// tracks background goroutines, like ones sending emails, so the server
+	// can wait for them when it shuts down
+	wg sync.WaitGroup
 	// add a template cache for parsed templates so we don't have to keep reparsing
 	templateCache map[string]*template.Template
 	// add formDecoder for automatically pulling out post body data
@@ -42,6 +77,31 @@ func main() {
 	addr := flag.String("addr", ":4000", "HTTP network address")
 	// Flag for the mySQL DSN string
 	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true", "MySQL data source name")
+	// Flags for the background worker that deletes expired snippets and sessions
+	reapInterval := flag.Duration("reap-interval", time.Hour, "How often to delete expired snippets and sessions (0 to disable)")
+	reapBatch := flag.Int("reap-batch", 1000, "Maximum number of expired rows deleted per query")
+	// Flags for rate limiting anonymous pastes from the command line
+	pasteInterval := flag.Duration("paste-interval", time.Minute, "Time it takes an IP address to earn another anonymous paste")
+	pasteBurst := flag.Int("paste-burst", 5, "Number of anonymous pastes an IP address can make at once")
+	// Flags for the links in emails and the mail server they're sent through.
+	// Without an SMTP host, emails are written to a log instead
+	baseURL := flag.String("base-url", "https://localhost:4000", "URL the server is reached at, used for links in emails and paste responses")
+	secret := flag.String("secret", "", "Key for signing links in emails, at least 32 characters (random if unset)")
+	smtpHost := flag.String("smtp-host", "", "SMTP server host (emails are logged if unset)")
+	smtpPort := flag.Int("smtp-port", 587, "SMTP server port")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	mailSender := flag.String("mail-sender", "Snippetbox <no-reply@snippetbox.example>", "From address of emails")
+	mailLog := flag.String("mail-log", "", "File emails are written to when there's no SMTP host (stdout if unset)")
+	// Flags for single sign-on through an OpenID Connect provider, turned on
+	// by setting the issuer. The provider has to allow <base-url>/user/login/oidc/callback
+	// as a redirect URL
+	oidcIssuer := flag.String("oidc-issuer", "", "URL of the OpenID Connect provider for single sign-on (off if unset)")
+	oidcClientID := flag.String("oidc-client-id", "", "Client ID registered with the OpenID Connect provider")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "Client secret registered with the OpenID Connect provider")
+	oidcName := flag.String("oidc-name", "single sign-on", "Name of the provider on the login page")
+	oidcAutoProvision := flag.Bool("oidc-auto-provision", false, "Create accounts for people logging in with single sign-on for the first time, instead of requiring them to sign up first")
+	oidcTrustEmail := flag.Bool("oidc-trust-email", false, "Treat the provider's email addresses as verified even without an email_verified claim")
 
 	// Parse value stored in flag and assign to addr. Without parsing, addr will always
 	// be set to the default value. Will panic if errors occur during parsing
@@ -67,18 +127,88 @@ func main() {
 		errorLog.Fatal(err)
 	}
 
+	mailTemplates, err := newMailTemplateCache()
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// Send emails through the SMTP server if there is one, otherwise write
+	// them to the mail log so the links in them can still be followed
+	var m mailer.Mailer
+	if *smtpHost != "" {
+		m, err = mailer.NewSMTP(*smtpHost, *smtpPort, *smtpUsername, *smtpPassword, *mailSender)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	} else {
+		var w io.Writer = os.Stdout
+		logName := "stdout"
+		if *mailLog != "" {
+			// The log holds working links, so only the owner can read it
+			f, err := os.OpenFile(*mailLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+			if err != nil {
+				errorLog.Fatal(err)
+			}
+			defer f.Close()
+			w = f
+			logName = *mailLog
+		}
+		m = mailer.NewLog(w, *mailSender)
+		infoLog.Printf("No SMTP host set, writing emails to %s", logName)
+	}
+
+	// Without a fixed key, links sent before a restart stop working
+	secretKey := []byte(*secret)
+	if len(secretKey) == 0 {
+		secretKey = make([]byte, 32)
+		_, err = rand.Read(secretKey)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Print("No -secret set, using a random one. Emailed links won't survive a restart")
+	} else if len(secretKey) < 32 {
+		errorLog.Fatal("-secret must be at least 32 characters long")
+	}
+
+	// Read the provider's configuration now, so a wrong issuer is found
+	// straight away rather than when someone tries to log in
+	var sso *oidcLogin
+	if *oidcIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		sso, err = newOIDCLogin(ctx, *oidcIssuer, *oidcClientID, *oidcClientSecret, strings.TrimSuffix(*baseURL, "/")+"/user/login/oidc/callback")
+		cancel()
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		sso.name = *oidcName
+		sso.autoProvision = *oidcAutoProvision
+		sso.trustEmail = *oidcTrustEmail
+	}
+
 	formDecoder := form.NewDecoder()
 
+	// The reaper can be disabled with a zero interval or batch size
+	reaping := *reapInterval > 0 && *reapBatch > 0
+
 	// Initialize a new sessionManager, set it to use our DB as the backing store
-	// Set session TTL to 12 hours
+	// Set session TTL to 12 hours. While the reaper runs, the store's own cleanup
+	// goroutine is turned off and expired sessions are deleted in batches by the
+	// reaper instead. Without the reaper the store has to clean up after itself
 	sessionManager := scs.New()
-	sessionManager.Store = mysqlstore.New(db)
+	if reaping {
+		sessionManager.Store = mysqlstore.NewWithCleanupInterval(db, 0)
+	} else {
+		sessionManager.Store = mysqlstore.New(db)
+	}
 	sessionManager.Lifetime = 12 * time.Hour
 
 	// Set Secure attribute on session cookies to indicate that this session cookie
 	// should only be sent by a user's browser when a HTTPS connection is being used
 	sessionManager.Cookie.Secure = true
 
+	// The reaper needs more of the users model than the handlers do
+	users := &models.UserModel{DB: db}
+
 	// Initialize new application struct with dependencies
 	// Inject initialized snippets DB pool, initialized users DB pool,
 	// template cache, and form decoder
@@ -86,7 +216,18 @@ func main() {
 		errorLog:       errorLog,
 		infoLog:        infoLog,
 		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db},
+		users:          users,
+		sessions:       &models.SessionModel{DB: db},
+		tokens:         &models.TokenModel{DB: db},
+		loginFailures:  &models.LoginFailureModel{DB: db},
+		pasteLimiter:   newRateLimiter(*pasteInterval, *pasteBurst),
+		mailLimiter:    newRateLimiter(time.Minute, 3),
+		inboxLimiter:   newRateLimiter(20*time.Minute, 3),
+		mailer:         m,
+		mailTemplates:  mailTemplates,
+		baseURL:        strings.TrimSuffix(*baseURL, "/"),
+		secretKey:      secretKey,
+		oidc:           sso,
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
@@ -110,11 +251,61 @@ func main() {
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
+	// Start the reaper unless it's been disabled
+	var r *reaper
+	if reaping {
+		r = &reaper{
+			interval:  *reapInterval,
+			batchSize: *reapBatch,
+			snippets:  app.snippets,
+			sessions:  app.sessions,
+			users:     users,
+			logins:    app.loginFailures,
+			errorLog:  errorLog,
+			infoLog:   infoLog,
+		}
+		r.start()
+	}
+
+	// Shut the server down gracefully on SIGINT or SIGTERM, letting requests in
+	// flight finish first. shutdownErr receives the result once it's done
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+		infoLog.Printf("Shutting down server, received %s", s)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
 	// Use the ListenAndServeTLS() function on our custom http.Server
-	// to start a new web server over HTTPS
+	// to start a new web server over HTTPS. It returns http.ErrServerClosed
+	// as soon as Shutdown() is called, anything else is a real error
 	infoLog.Printf("Starting server on %s", *addr)
 	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
+	if !errors.Is(err, http.ErrServerClosed) {
+		errorLog.Fatal(err)
+	}
+
+	// Wait for the requests in flight to finish before stopping the reaper. If
+	// they didn't finish in time, the reaper and background work still get
+	// to finish before the server exits with an error
+	err = <-shutdownErr
+	if err != nil {
+		errorLog.Print(err)
+	}
+	if r != nil {
+		r.shutdown()
+	}
+	// Let emails that are still being sent go out
+	app.wg.Wait()
+	if err != nil {
+		os.Exit(1)
+	}
+	infoLog.Print("Server stopped")
 }
 
 // openDB wraps sql.Open() and return a sql.DB connection pool
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Converts markdown to HTML. Goldmark already leaves out raw HTML and dangerous
+// link URLs by default, its output is sanitized anyway in case that changes
+var markdownRenderer = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+)
+
+// Allowlist of the elements and attributes markdown snippets may produce.
+// Anything else, including scripts, inline event handlers, style attributes
+// and javascript: URLs, is stripped out
+var markdownPolicy = newMarkdownPolicy()
+
+func newMarkdownPolicy() *bluemonday.Policy {
+	// The UGC policy allows the usual formatting elements and only http, https
+	// and mailto links
+	p := bluemonday.UGCPolicy()
+	// Keep the language hint on fenced code blocks
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+	// Links in snippets open somewhere else and shouldn't leak the snippet URL
+	p.RequireNoReferrerOnLinks(true)
+	return p
+}
+
+// Renders markdown as sanitized HTML
+func renderMarkdown(content string) (template.HTML, error) {
+	buf := new(bytes.Buffer)
+	err := markdownRenderer.Convert([]byte(content), buf)
+	if err != nil {
+		return "", err
+	}
+
+	// Sanitized output is safe to use as HTML
+	return template.HTML(markdownPolicy.SanitizeBytes(buf.Bytes())), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import "testing"
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "Formatting",
+			markdown: "# Title\n\n**bold**",
+			want:     "<h1>Title</h1>\n<p><strong>bold</strong></p>\n",
+		},
+		{
+			name:     "Link",
+			markdown: "[x](https://example.com)",
+			want:     "<p><a href=\"https://example.com\" rel=\"nofollow noreferrer\">x</a></p>\n",
+		},
+		{
+			name:     "JavaScript link",
+			markdown: "[x](javascript:alert(1))",
+			want:     "<p>x</p>\n",
+		},
+		{
+			name:     "Raw script",
+			markdown: "<script>alert(1)</script>",
+			want:     "\n",
+		},
+		{
+			name:     "Raw event handler",
+			markdown: "<img src=x onerror=alert(1)>",
+			want:     "\n",
+		},
+		{
+			name:     "Fenced code with a language",
+			markdown: "```go\nfmt.Println()\n```",
+			want:     "<pre><code class=\"language-go\">fmt.Println()\n</code></pre>\n",
+		},
+		{
+			name:     "Fenced code with a language that breaks out of the class",
+			markdown: "```x\" onclick=\"y\nz\n```",
+			want:     "<pre><code>z\n</code></pre>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderMarkdown(tt.markdown)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Goldmark drops raw HTML before the policy sees it, so the policy is checked
+// on its own too, in case that ever changes
+func TestMarkdownPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "Event handler",
+			html: `<p onclick="x()">hi</p>`,
+			want: `<p>hi</p>`,
+		},
+		{
+			name: "JavaScript link",
+			html: `<a href="javascript:alert(1)">x</a>`,
+			want: `x`,
+		},
+		{
+			name: "Link without referrer",
+			html: `<a href="https://example.com">x</a>`,
+			want: `<a href="https://example.com" rel="nofollow noreferrer">x</a>`,
+		},
+		{
+			name: "Language class",
+			html: `<code class="language-go">x</code>`,
+			want: `<code class="language-go">x</code>`,
+		},
+		{
+			name: "Other class",
+			html: `<code class="language-go evil">x</code>`,
+			want: `<code>x</code>`,
+		},
+		{
+			name: "Style",
+			html: `<span style="color:red">x</span>`,
+			want: `<span>x</span>`,
+		},
+		{
+			name: "Iframe",
+			html: `<iframe src="https://example.com"></iframe>`,
+			want: ``,
+		},
+		{
+			name: "Script",
+			html: `<script>alert(1)</script>ok`,
+			want: `ok`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := markdownPolicy.Sanitize(tt.html)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
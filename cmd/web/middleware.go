@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+
+	"github.com/dwang288/snippetbox/internal/models"
 )
 
 // Middleware for adding security headers to response, calls next handler in chain
@@ -50,7 +54,8 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !app.isAuthenticated(r) {
-			app.sessionManager.Put(r.Context(), "redirectPathAfterLogin", r.URL.Path)
+			// Keep the query string too, e.g. the snippet being forked
+			app.sessionManager.Put(r.Context(), "redirectPathAfterLogin", r.URL.RequestURI())
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 			return
 		}
@@ -64,6 +69,21 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// Like requireAuthentication, but answers with a 401 instead of redirecting to
+// the login page
+func (app *application) requireAPIAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.apiError(w, http.StatusUnauthorized, "you must be authenticated to access this resource")
+			return
+		}
+
+		w.Header().Add("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip to next handler if user is not authenticated (ID is 0)
@@ -73,21 +93,76 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// Check if the user with this ID exists in the DB, if not then
-		// return a server error
-		exists, err := app.users.Exists(id)
+		// Check if the user with this ID exists in the DB, hasn't been
+		// disabled and hasn't been logged out everywhere since this session
+		// logged in. Return a server error if the check fails
+		generation := app.sessionManager.GetInt(r.Context(), "sessionGeneration")
+		loggedIn, err := app.users.LoggedIn(id, generation)
 		if err != nil {
 			app.serverError(w, err)
 			return
 		}
 
 		// Sets the isAuthenticatedContextKey in the new context to be true
-		// Adds it to the request
-		if exists {
+		// and remembers who the user is. Adds it to the request
+		if loggedIn {
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, authenticatedUserIDContextKey, id)
 			r = r.WithContext(ctx)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Middleware for authenticating API and command line callers with a personal
+// API token sent as "Authorization: Bearer <token>". Sets the same context
+// values as authenticate, along with the token so its scopes can be checked.
+// Requests without the header are passed on untouched, a bad token is a 401
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Tell caches that the response depends on the header
+		w.Header().Add("Vary", "Authorization")
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		plaintext, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			app.invalidTokenResponse(w)
+			return
+		}
+
+		token, err := app.tokens.Authenticate(plaintext)
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				app.invalidTokenResponse(w)
+			} else {
+				app.apiServerError(w, err)
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, authenticatedUserIDContextKey, token.UserID)
+		ctx = context.WithValue(ctx, tokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Middleware for checking that a request authenticated with an API token was
+// given the scope. Browser sessions can do anything the user can
+func (app *application) requireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := r.Context().Value(tokenContextKey).(*models.Token)
+		if ok && !token.HasScope(scope) {
+			app.apiError(w, http.StatusForbidden, fmt.Sprintf("this token doesn't have the %s scope", scope))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
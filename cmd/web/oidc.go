@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dwang288/snippetbox/internal/models"
+	"github.com/dwang288/snippetbox/internal/validator"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcLogin logs users in through an OpenID Connect identity provider, using
+// the authorization code flow with PKCE
+type oidcLogin struct {
+	// Name of the provider shown on the login button
+	name     string
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	// Create users for people logging in for the first time, instead of only
+	// letting in users whose email address already has an account. Off by
+	// default, since it lets anyone the provider will vouch for sign up
+	autoProvision bool
+	// Treat email addresses as verified even without an email_verified claim,
+	// for providers that only hand out addresses they own
+	trustEmail bool
+}
+
+// Fetches the provider's configuration from its discovery document. The
+// redirect URL is the callback route on this server
+func newOIDCLogin(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*oidcLogin, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcLogin{
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// Claims read from the ID token besides the subject
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// Reasons an identity can't be logged in
+var (
+	errOIDCNoEmail      = errors.New("oidc: no verified email address")
+	errOIDCNoAccount    = errors.New("oidc: no account for email address")
+	errOIDCEmailInUse   = errors.New("oidc: email address taken during login")
+	errOIDCDisabledUser = errors.New("oidc: disabled user")
+	errOIDCUnverified   = errors.New("oidc: account email address not verified")
+)
+
+// What users are told for each of the reasons above
+var oidcErrorMessages = map[error]string{
+	errOIDCNoEmail:      "Your identity provider didn't share a verified email address.",
+	errOIDCNoAccount:    "There's no account for your email address. Sign up first, then log in with single sign-on.",
+	errOIDCEmailInUse:   "An account with your email address was created while you were logging in. Please try again.",
+	errOIDCDisabledUser: "This account has been disabled.",
+	errOIDCUnverified:   "The account with your email address hasn't been verified yet. Verify it from the email we sent, then log in with single sign-on.",
+}
+
+// Returns a random string for the state and nonce parameters
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sends the user to the identity provider. The state, nonce and PKCE verifier
+// are kept in the session to check the response against
+func (app *application) userLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	app.sessionManager.Put(r.Context(), "oidcState", state)
+	app.sessionManager.Put(r.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", verifier)
+
+	url := app.oidc.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// The identity provider sends the user back here with a code, which is traded
+// for an ID token saying who they are
+func (app *application) userLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	// Each login attempt can only come back once
+	state := app.sessionManager.PopString(r.Context(), "oidcState")
+	nonce := app.sessionManager.PopString(r.Context(), "oidcNonce")
+	verifier := app.sessionManager.PopString(r.Context(), "oidcVerifier")
+
+	// Failures are logged for the admin, the user just gets asked to try again
+	fail := func(message string, err error) {
+		if err != nil {
+			app.infoLog.Printf("single sign-on failed: %v", err)
+		}
+		app.sessionManager.Put(r.Context(), "flash", message)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	}
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		fail("Single sign-on was cancelled or failed. Please try again.", errors.New(e+": "+query.Get("error_description")))
+		return
+	}
+	// The state ties the response to the login this browser started
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		fail("Single sign-on failed. Please try again.", errors.New("state mismatch"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	token, err := app.oidc.config.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		fail("Single sign-on failed. Please try again.", err)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		fail("Single sign-on failed. Please try again.", errors.New("no id_token in token response"))
+		return
+	}
+
+	// Checks the signature, issuer, audience and expiry
+	idToken, err := app.oidc.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		fail("Single sign-on failed. Please try again.", err)
+		return
+	}
+	// The nonce ties the ID token to this login, so it can't be replayed
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(nonce), []byte(idToken.Nonce)) != 1 {
+		fail("Single sign-on failed. Please try again.", errors.New("nonce mismatch"))
+		return
+	}
+
+	var claims oidcClaims
+	err = idToken.Claims(&claims)
+	if err != nil {
+		fail("Single sign-on failed. Please try again.", err)
+		return
+	}
+
+	user, err := app.oidcUser(idToken.Issuer, idToken.Subject, &claims)
+	if err != nil {
+		message, ok := oidcErrorMessages[err]
+		if ok {
+			fail(message, nil)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// The provider replaces the password, but not an authenticator app
+	if user.TwoFactor {
+		app.startTwoFactorLogin(w, r, user)
+		return
+	}
+	app.logIn(w, r, user.ID)
+}
+
+// Maps an identity at the provider to a user. Identities seen before are
+// linked to their user already. Otherwise the identity is linked to the
+// verified user with the same verified email address, or a new user is
+// created for it if auto-provisioning is on
+func (app *application) oidcUser(issuer, subject string, claims *oidcClaims) (*models.User, error) {
+	user, err := app.users.GetByIdentity(issuer, subject)
+	if err == nil {
+		if user.Disabled {
+			return nil, errOIDCDisabledUser
+		}
+		return user, nil
+	}
+	if !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	// Anyone can put any address in their profile at some providers, so only
+	// addresses the provider has checked can be matched to accounts
+	email := strings.TrimSpace(claims.Email)
+	if !(claims.EmailVerified || app.oidc.trustEmail) || !validator.Matches(email, validator.EmailRX) {
+		return nil, errOIDCNoEmail
+	}
+
+	user, err = app.users.GetByEmail(email)
+	if err == nil {
+		if user.Disabled {
+			return nil, errOIDCDisabledUser
+		}
+		// Anyone can sign up with an address they don't own. Linking such an
+		// account would hand the real owner a password the sign-up knows, so
+		// only accounts that proved they own the address are linked
+		if !user.Verified {
+			return nil, errOIDCUnverified
+		}
+		err = app.users.LinkIdentity(user.ID, issuer, subject)
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	if !app.oidc.autoProvision {
+		return nil, errOIDCNoAccount
+	}
+
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	id, err := app.users.InsertWithIdentity(name, email, issuer, subject)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			return nil, errOIDCEmailInUse
+		}
+		return nil, err
+	}
+	return app.users.Get(id)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/dwang288/snippetbox/internal/mockidp"
+	"github.com/dwang288/snippetbox/internal/models"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// Starts the mock identity provider that cmd/mockidp runs
+func newMockIdP(t *testing.T) *httptest.Server {
+	ts := httptest.NewUnstartedServer(nil)
+	idp, err := mockidp.New("http://"+ts.Listener.Addr().String(), "snippetbox", "secret", log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Config.Handler = idp.Routes()
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// Users kept in memory. Only the methods single sign-on needs are
+// implemented, the rest panic through the nil embedded interface
+type oidcTestUsers struct {
+	models.UserModelInterface
+	users map[int]*models.User
+	// User IDs by issuer and subject
+	identities map[[2]string]int
+}
+
+func (m *oidcTestUsers) Get(id int) (*models.User, error) {
+	u, ok := m.users[id]
+	if !ok {
+		return nil, models.ErrNoRecord
+	}
+	return u, nil
+}
+
+func (m *oidcTestUsers) GetByEmail(email string) (*models.User, error) {
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *oidcTestUsers) GetByIdentity(issuer, subject string) (*models.User, error) {
+	id, ok := m.identities[[2]string{issuer, subject}]
+	if !ok {
+		return nil, models.ErrNoRecord
+	}
+	return m.Get(id)
+}
+
+func (m *oidcTestUsers) LinkIdentity(id int, issuer, subject string) error {
+	m.identities[[2]string{issuer, subject}] = id
+	return nil
+}
+
+func (m *oidcTestUsers) InsertWithIdentity(name, email, issuer, subject string) (int, error) {
+	id := len(m.users) + 1
+	m.users[id] = &models.User{ID: id, Name: name, Email: email, Verified: true}
+	return id, m.LinkIdentity(id, issuer, subject)
+}
+
+func (m *oidcTestUsers) LoggedIn(id, generation int) (bool, error) {
+	u, ok := m.users[id]
+	return ok && !u.Disabled && u.SessionGeneration == generation, nil
+}
+
+// Where a login through the mock identity provider ended up
+type oidcTestResult struct {
+	location        string
+	flash           string
+	authenticatedID int
+	twoFactorID     int
+}
+
+// Starts the web server with single sign-on through the mock identity
+// provider, and an extra page that tells what's in the session
+func newOIDCTestServer(t *testing.T, app *application, idp *httptest.Server) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/", app.routes())
+	mux.Handle("/test/session", app.sessionManager.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"flash":           app.sessionManager.PopString(r.Context(), "flash"),
+			"authenticatedID": app.sessionManager.GetInt(r.Context(), "authenticatedUserID"),
+			"twoFactorID":     app.sessionManager.GetInt(r.Context(), "twoFactorUserID"),
+		})
+	})))
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	var err error
+	app.oidc, err = newOIDCLogin(context.Background(), idp.URL, "snippetbox", "secret", ts.URL+"/user/login/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+// Logs in through the mock identity provider in a new browser session. modify
+// can change what's posted to the provider's login page, whose form carries
+// the authorization request as well as the claims of the ID token, to play an
+// attacker or a misbehaving provider
+func oidcTestLogin(t *testing.T, ts *httptest.Server, modify func(login url.Values)) oidcTestResult {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	get := func(u string) *http.Response {
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// The web server sends the browser to the provider
+	resp := get(ts.URL + "/user/login/oidc")
+	authURL, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := authURL.Query()
+	login.Set("sub", "alice-at-idp")
+	login.Set("email", "alice@example.com")
+	login.Set("email_verified", "true")
+	login.Set("action", "allow")
+	if modify != nil {
+		modify(login)
+	}
+	authURL.RawQuery = ""
+
+	// The user logs in, and the provider sends the browser back with a code
+	resp, err = client.PostForm(authURL.String(), login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("got status %d from the provider's login page, want %d", resp.StatusCode, http.StatusSeeOther)
+	}
+	resp = get(resp.Header.Get("Location"))
+	result := oidcTestResult{location: resp.Header.Get("Location")}
+
+	resp, err = client.Get(ts.URL + "/test/session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var session struct {
+		Flash           string
+		AuthenticatedID int
+		TwoFactorID     int
+	}
+	err = json.NewDecoder(resp.Body).Decode(&session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.flash = session.Flash
+	result.authenticatedID = session.AuthenticatedID
+	result.twoFactorID = session.TwoFactorID
+	return result
+}
+
+func TestUserLoginOIDCCallback(t *testing.T) {
+	idp := newMockIdP(t)
+	failed := "Single sign-on failed. Please try again."
+	alice := func() *models.User {
+		return &models.User{ID: 1, Name: "Alice", Email: "alice@example.com", Verified: true}
+	}
+
+	tests := []struct {
+		name          string
+		users         []*models.User
+		linked        bool
+		autoProvision bool
+		modify        func(login url.Values)
+		want          oidcTestResult
+		// ID of the user the identity should end up linked to, 0 for none
+		wantLinked int
+	}{
+		{
+			name:       "Linked identity",
+			users:      []*models.User{alice()},
+			linked:     true,
+			want:       oidcTestResult{location: "/snippet/create", authenticatedID: 1},
+			wantLinked: 1,
+		},
+		{
+			name:       "Verified account",
+			users:      []*models.User{alice()},
+			want:       oidcTestResult{location: "/snippet/create", authenticatedID: 1},
+			wantLinked: 1,
+		},
+		{
+			name: "Unverified account",
+			users: []*models.User{func() *models.User {
+				u := alice()
+				u.Verified = false
+				return u
+			}()},
+			want: oidcTestResult{location: "/user/login", flash: oidcErrorMessages[errOIDCUnverified]},
+		},
+		{
+			name:   "Email not verified by the provider",
+			users:  []*models.User{alice()},
+			modify: func(login url.Values) { login.Del("email_verified") },
+			want:   oidcTestResult{location: "/user/login", flash: oidcErrorMessages[errOIDCNoEmail]},
+		},
+		{
+			name: "No account",
+			want: oidcTestResult{location: "/user/login", flash: oidcErrorMessages[errOIDCNoAccount]},
+		},
+		{
+			name:          "No account with auto-provisioning",
+			autoProvision: true,
+			want:          oidcTestResult{location: "/snippet/create", authenticatedID: 1},
+			wantLinked:    1,
+		},
+		{
+			name: "Two-factor authentication",
+			users: []*models.User{func() *models.User {
+				u := alice()
+				u.TwoFactor = true
+				return u
+			}()},
+			linked:     true,
+			want:       oidcTestResult{location: "/user/login/2fa", twoFactorID: 1},
+			wantLinked: 1,
+		},
+		{
+			name:   "Wrong state",
+			users:  []*models.User{alice()},
+			modify: func(login url.Values) { login.Set("state", "forged") },
+			want:   oidcTestResult{location: "/user/login", flash: failed},
+		},
+		{
+			name:   "Wrong nonce",
+			users:  []*models.User{alice()},
+			modify: func(login url.Values) { login.Set("nonce", "replayed") },
+			want:   oidcTestResult{location: "/user/login", flash: failed},
+		},
+		{
+			name:  "Code issued for another PKCE challenge",
+			users: []*models.User{alice()},
+			modify: func(login url.Values) {
+				sum := sha256.Sum256([]byte("attacker's verifier"))
+				login.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+			},
+			want: oidcTestResult{location: "/user/login", flash: failed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &oidcTestUsers{users: map[int]*models.User{}, identities: map[[2]string]int{}}
+			for _, u := range tt.users {
+				users.users[u.ID] = u
+				if tt.linked {
+					users.identities[[2]string{idp.URL, "alice-at-idp"}] = u.ID
+				}
+			}
+
+			app := &application{
+				errorLog:       log.New(io.Discard, "", 0),
+				infoLog:        log.New(io.Discard, "", 0),
+				users:          users,
+				sessionManager: scs.New(),
+			}
+			ts := newOIDCTestServer(t, app, idp)
+			app.oidc.autoProvision = tt.autoProvision
+
+			got := oidcTestLogin(t, ts, tt.modify)
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+
+			linked := users.identities[[2]string{idp.URL, "alice-at-idp"}]
+			if linked != tt.wantLinked {
+				t.Errorf("got identity linked to user %d, want %d", linked, tt.wantLinked)
+			}
+		})
+	}
+}
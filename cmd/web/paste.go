@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dwang288/snippetbox/internal/ipaddr"
+	"github.com/dwang288/snippetbox/internal/models"
+)
+
+// Settings that can be given to the paste endpoint, by query parameter name and
+// the header that can be used instead. Query parameters win if both are set
+var pasteParams = map[string]string{
+	"title":      "X-Title",
+	"expires":    "X-Expires",
+	"language":   "X-Language",
+	"visibility": "X-Visibility",
+	"tags":       "X-Tags",
+	"views":      "X-Views",
+	"burn":       "X-Burn",
+}
+
+// POST / creates a snippet from the request body and answers with nothing but
+// its URL, so it can be used straight from a shell:
+//
+//	curl --data-binary @notes.txt 'https://host/?title=Notes&expires=1'
+//	curl -F file=@main.go https://host/
+//
+// Anyone can paste, but anonymous pastes are rate limited per IP address, or
+// per /64 network for IPv6, and can't be private or kept forever. Sending an
+// API token with the write scope pastes as its user instead. Pastes are
+// unlisted unless asked otherwise
+func (app *application) paste(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		ok, wait := app.pasteLimiter.allow(ipaddr.Network(clientIP(r)))
+		if !ok {
+			seconds := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			http.Error(w, fmt.Sprintf("Too many pastes, try again in %d seconds or use an API token", seconds), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	content, filename, err := pasteContent(r)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.clientError(w, http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	param := func(name string) string {
+		if v := r.URL.Query().Get(name); v != "" {
+			return v
+		}
+		return r.Header.Get(pasteParams[name])
+	}
+
+	form := snippetCreateForm{
+		Title:      param("title"),
+		Content:    content,
+		Expires:    param("expires"),
+		Tags:       param("tags"),
+		Language:   param("language"),
+		Format:     models.FormatCode,
+		Visibility: param("visibility"),
+	}
+	if form.Title == "" {
+		form.Title = "Untitled paste"
+		// Cut down to the longest title validate accepts, so a long
+		// filename doesn't get the paste refused
+		if filename != "" {
+			form.Title = truncate(filename, 100)
+		}
+	}
+	if form.Expires == "" {
+		form.Expires = "7"
+	}
+	if form.Visibility == "" {
+		form.Visibility = models.VisibilityUnlisted
+	}
+	// Uploaded files give away their language by their name
+	if form.Language == "" && filename != "" {
+		form.Language = detectFileLanguage(filename, content)
+	}
+	if v := param("views"); v != "" {
+		form.MaxViews, err = strconv.Atoi(v)
+		form.CheckField(err == nil, "views", "This field must be a number")
+	}
+	if v := param("burn"); v != "" {
+		form.BurnAfterReading, err = strconv.ParseBool(v)
+		form.CheckField(err == nil, "burn", "This field must be true or false")
+	}
+
+	form.validate()
+	if userID == 0 {
+		form.checkAnonymousPaste()
+	}
+
+	// List the errors one per line, under the names of the parameters
+	if !form.Valid() {
+		keys := make([]string, 0, len(form.FieldErrors))
+		for key := range form.FieldErrors {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		for _, key := range keys {
+			name := key
+			if name == "maxViews" {
+				name = "views"
+			}
+			fmt.Fprintf(w, "%s: %s\n", name, form.FieldErrors[key])
+		}
+		return
+	}
+
+	snippet := form.snippet()
+	snippet.UserID = userID
+	snippet.Expires = form.expiry(nil)
+
+	_, err = app.snippets.Insert(snippet)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The Host header is whatever the client sent, so links are built from the
+	// configured base URL instead
+	url := fmt.Sprintf("%s/snippet/view/%s", app.baseURL, snippet.Ref())
+	w.Header().Set("Location", url)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, url)
+}
+
+// Anonymous pastes have no author, so they can't be kept forever and nobody
+// could ever open a private one
+func (form *snippetCreateForm) checkAnonymousPaste() {
+	form.CheckField(form.Expires != expiresNever, "expires", "Only logged in users can create snippets that never expire")
+	form.CheckField(form.Visibility != models.VisibilityPrivate, "visibility", "Only logged in users can create private snippets")
+}
+
+// Reads what's being pasted, which is either the whole request body or the
+// first file of a multipart upload. Returns the name of the uploaded file, if
+// there was one
+func pasteContent(r *http.Request) (string, string, error) {
+	var content []byte
+	var filename string
+
+	// curl -F sends a multipart form. Anything else, like the form encoding
+	// curl --data-binary claims to send, is taken as is
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err := r.ParseMultipartForm(maxRequestBytes)
+		if err != nil {
+			return "", "", err
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		// Prefer the field called file, but take whatever was uploaded
+		headers := r.MultipartForm.File["file"]
+		if len(headers) == 0 {
+			for _, h := range r.MultipartForm.File {
+				headers = h
+				break
+			}
+		}
+		if len(headers) == 0 {
+			return "", "", errors.New("no file was uploaded")
+		}
+
+		file, err := headers[0].Open()
+		if err != nil {
+			return "", "", err
+		}
+		defer file.Close()
+
+		content, err = io.ReadAll(file)
+		if err != nil {
+			return "", "", err
+		}
+		filename = headers[0].Filename
+	} else {
+		var err error
+		content, err = io.ReadAll(r.Body)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	if !utf8.Valid(content) {
+		return "", "", errors.New("only UTF-8 text can be pasted")
+	}
+	return string(content), filename, nil
+}
+
+// Returns the first n characters of s
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dwang288/snippetbox/internal/models"
+)
+
+func TestCheckAnonymousPaste(t *testing.T) {
+	tests := []struct {
+		name       string
+		expires    string
+		visibility string
+		wantErrors []string
+	}{
+		{
+			name:       "Unlisted",
+			expires:    "7",
+			visibility: models.VisibilityUnlisted,
+		},
+		{
+			name:       "Public",
+			expires:    "365",
+			visibility: models.VisibilityPublic,
+		},
+		{
+			name:       "Private",
+			expires:    "7",
+			visibility: models.VisibilityPrivate,
+			wantErrors: []string{"visibility"},
+		},
+		{
+			name:       "Never expires",
+			expires:    expiresNever,
+			visibility: models.VisibilityUnlisted,
+			wantErrors: []string{"expires"},
+		},
+		{
+			name:       "Private and never expires",
+			expires:    expiresNever,
+			visibility: models.VisibilityPrivate,
+			wantErrors: []string{"expires", "visibility"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := snippetCreateForm{Expires: tt.expires, Visibility: tt.visibility}
+			form.checkAnonymousPaste()
+
+			if len(form.FieldErrors) != len(tt.wantErrors) {
+				t.Errorf("got errors %v, want errors for %v", form.FieldErrors, tt.wantErrors)
+			}
+			for _, key := range tt.wantErrors {
+				if _, ok := form.FieldErrors[key]; !ok {
+					t.Errorf("got no error for %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{name: "Short", s: "main.go", n: 100, want: "main.go"},
+		{name: "Exactly n", s: "abc", n: 3, want: "abc"},
+		{name: "Long", s: "abcdef", n: 3, want: "abc"},
+		{name: "Multibyte", s: "日本語.txt", n: 2, want: "日本"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncate(tt.s, tt.n)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per client, e.g. per IP address. Each client
+// can make burst requests at once, after which it gets another request every
+// interval. Clients that have been idle long enough to have a full bucket again
+// are forgotten, so the map doesn't grow forever
+type rateLimiter struct {
+	interval time.Duration
+	burst    int
+
+	mu      sync.Mutex
+	clients map[string]*bucket
+	// When idle clients were last cleared out of the map
+	lastCleanup time.Time
+}
+
+// Requests a single client has left, as of when it was last seen
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// Returns a limiter that allows burst requests at once and one more every interval
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{
+		interval:    interval,
+		burst:       burst,
+		clients:     make(map[string]*bucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+// allow takes a request from the client's bucket. Returns false if the bucket
+// is empty, along with how long the client has to wait for the next request
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	// Time after which an idle client's bucket is full again
+	refill := l.interval * time.Duration(l.burst)
+	if now.Sub(l.lastCleanup) > refill {
+		for key, b := range l.clients {
+			if now.Sub(b.lastSeen) > refill {
+				delete(l.clients, key)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), lastSeen: now}
+		l.clients[client] = b
+	}
+
+	// Top the bucket up for the time since the client was last seen
+	b.tokens += float64(now.Sub(b.lastSeen)) / float64(l.interval)
+	if b.tokens > float64(l.burst) {
+		b.tokens = float64(l.burst)
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.interval))
+	}
+	b.tokens--
+	return true, 0
+}
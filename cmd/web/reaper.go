@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/dwang288/snippetbox/internal/models"
+)
+
+// reaper is a background worker that periodically deletes expired snippets,
+// sessions, password reset tokens and failed login counts. Queries already
+// filter expired rows out, so this only keeps the tables from growing forever
+type reaper struct {
+	interval time.Duration
+	// Maximum number of rows deleted per query, so a big backlog is worked
+	// through in small transactions instead of one long lock
+	batchSize int
+	snippets  *models.SnippetModel
+	sessions  *models.SessionModel
+	users     *models.UserModel
+	logins    *models.LoginFailureModel
+	errorLog  *log.Logger
+	infoLog   *log.Logger
+
+	// Running totals of what has been removed since the reaper started
+	snippetsReaped atomic.Int64
+	sessionsReaped atomic.Int64
+
+	// Closing stop asks the worker to exit, done is closed once it has
+	stop chan struct{}
+	done chan struct{}
+}
+
+// start runs the reaper in its own goroutine until shutdown is called. The
+// first pass happens straight away
+func (r *reaper) start() {
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			r.run()
+			select {
+			case <-ticker.C:
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// shutdown stops the reaper and waits for a pass in progress to finish
+func (r *reaper) shutdown() {
+	close(r.stop)
+	<-r.done
+	r.infoLog.Printf("Reaper stopped, removed %d snippets and %d sessions in total",
+		r.snippetsReaped.Load(), r.sessionsReaped.Load())
+}
+
+// run does a single pass, deleting batches until nothing expired is left
+func (r *reaper) run() {
+	snippets := r.reap("snippets", r.snippets.DeleteExpired)
+	sessions := r.reap("sessions", r.sessions.DeleteExpired)
+	r.snippetsReaped.Add(int64(snippets))
+	r.sessionsReaped.Add(int64(sessions))
+
+	if snippets > 0 || sessions > 0 {
+		r.infoLog.Printf("Reaper removed %d expired snippets and %d expired sessions", snippets, sessions)
+	}
+
+	// Expired reset tokens can't be used anyway, and old login failures don't
+	// count any more, so they aren't worth counting either
+	r.reap("password resets", r.users.DeleteExpiredPasswordResets)
+	r.reap("login failures", r.logins.DeleteExpired)
+}
+
+// Calls deleteExpired until it deletes less than a full batch and returns the
+// number of rows deleted. Gives up early on errors or when asked to stop, the
+// next pass picks up where this one left off
+func (r *reaper) reap(name string, deleteExpired func(limit int) (int, error)) int {
+	total := 0
+	for {
+		n, err := deleteExpired(r.batchSize)
+		total += n
+		if err != nil {
+			r.errorLog.Printf("reaping %s: %v", name, err)
+			return total
+		}
+		if n < r.batchSize {
+			return total
+		}
+
+		select {
+		case <-r.stop:
+			return total
+		default:
+		}
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"net/http"
 
+	"github.com/dwang288/snippetbox/internal/models"
+
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -23,18 +25,39 @@ func (app *application) routes() http.Handler {
 	// Replace all http.Servemuxes with httprouter, use clean URL pathing
 	// Wrap handlers that use session data with session middleware
 	router.Handler(http.MethodGet, "/", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.home))))
+	router.Handler(http.MethodGet, "/snippets", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.snippetList))))
+	router.Handler(http.MethodGet, "/tag/:name", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.snippetList))))
+	router.Handler(http.MethodGet, "/search", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.search))))
 	router.Handler(http.MethodGet, "/snippet/view/:id", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.snippetView))))
+	router.Handler(http.MethodGet, "/snippet/view/:id/history", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.snippetHistory))))
+	router.Handler(http.MethodGet, "/snippet/view/:id/diff", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.snippetDiff))))
+	// Raw content can also be fetched with an API token, e.g. by curl
+	router.Handler(http.MethodGet, "/snippet/raw/:id", app.sessionManager.LoadAndSave(app.authenticate(app.authenticateToken(app.requireScope(models.ScopeRead, http.HandlerFunc(app.snippetRaw))))))
+	router.Handler(http.MethodGet, "/snippet/download/:id", app.sessionManager.LoadAndSave(app.authenticate(app.authenticateToken(app.requireScope(models.ScopeRead, http.HandlerFunc(app.snippetDownload))))))
+	router.Handler(http.MethodGet, "/snippet/zip/:id", app.sessionManager.LoadAndSave(app.authenticate(app.authenticateToken(app.requireScope(models.ScopeRead, http.HandlerFunc(app.snippetZip))))))
 
 	// Requires users to be logged in
 	router.Handler(http.MethodGet, "/account/view", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.accountView)))))
 	router.Handler(http.MethodGet, "/snippet/create", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.snippetCreate)))))
 	router.Handler(http.MethodPost, "/snippet/create", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.snippetCreatePost)))))
+	router.Handler(http.MethodGet, "/snippet/edit/:id", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.snippetEdit)))))
+	router.Handler(http.MethodPost, "/snippet/edit/:id", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.snippetEditPost)))))
+	router.Handler(http.MethodPost, "/snippet/delete/:id", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.snippetDeletePost)))))
 
 	// User authentication routes
 	router.Handler(http.MethodGet, "/user/signup", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.userSignup))))
 	router.Handler(http.MethodPost, "/user/signup", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.userSignupPost))))
 	router.Handler(http.MethodGet, "/user/login", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.userLogin))))
 	router.Handler(http.MethodPost, "/user/login", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.userLoginPost))))
+	router.Handler(http.MethodGet, "/user/login/2fa", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.userLoginTwoFactor))))
+	router.Handler(http.MethodPost, "/user/login/2fa", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.userLoginTwoFactorPost))))
+	router.Handler(http.MethodGet, "/user/login/oidc", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.userLoginOIDC))))
+	router.Handler(http.MethodGet, "/user/login/oidc/callback", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.userLoginOIDCCallback))))
+
+	// Email verification routes
+	router.Handler(http.MethodGet, "/user/verify", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.userVerify))))
+	router.Handler(http.MethodPost, "/user/verify", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.userVerifyPost))))
+	router.Handler(http.MethodGet, "/user/verify/:token", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.userVerifyToken))))
 
 	// Requires users to be logged in
 	router.Handler(http.MethodPost, "/user/logout", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.userLogoutPost)))))
@@ -43,6 +66,35 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodGet, "/account/password/update", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.accountPasswordUpdate)))))
 	router.Handler(http.MethodPost, "/account/password/update", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.accountPasswordUpdatePost)))))
 
+	// Forgotten password routes
+	router.Handler(http.MethodGet, "/user/password/forgot", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.passwordForgot))))
+	router.Handler(http.MethodPost, "/user/password/forgot", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.passwordForgotPost))))
+	router.Handler(http.MethodGet, "/user/password/reset/:token", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.passwordReset))))
+	router.Handler(http.MethodPost, "/user/password/reset/:token", app.sessionManager.LoadAndSave(app.authenticate(http.HandlerFunc(app.passwordResetPost))))
+
+	// Two-factor authentication routes
+	router.Handler(http.MethodGet, "/account/2fa", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.accountTwoFactor)))))
+	router.Handler(http.MethodGet, "/account/2fa/qr.png", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.accountTwoFactorQR)))))
+	router.Handler(http.MethodPost, "/account/2fa/enable", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.accountTwoFactorEnablePost)))))
+	router.Handler(http.MethodPost, "/account/2fa/disable", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.accountTwoFactorDisablePost)))))
+
+	// API token routes
+	router.Handler(http.MethodPost, "/account/tokens/create", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.accountTokenCreatePost)))))
+	router.Handler(http.MethodPost, "/account/tokens/revoke/:id", app.sessionManager.LoadAndSave(app.authenticate(app.requireAuthentication(http.HandlerFunc(app.accountTokenRevokePost)))))
+
+	// JSON API, answers with JSON errors instead of redirects and error pages.
+	// Besides browser sessions, callers can authenticate with an API token
+	router.Handler(http.MethodGet, "/api/v1/account", app.sessionManager.LoadAndSave(app.authenticate(app.authenticateToken(app.requireAPIAuthentication(http.HandlerFunc(app.apiAccount))))))
+	router.Handler(http.MethodGet, "/api/v1/account/snippets", app.sessionManager.LoadAndSave(app.authenticate(app.authenticateToken(app.requireAPIAuthentication(app.requireScope(models.ScopeRead, http.HandlerFunc(app.apiAccountSnippets)))))))
+	router.Handler(http.MethodGet, "/api/v1/snippets", app.sessionManager.LoadAndSave(app.authenticate(app.authenticateToken(app.requireScope(models.ScopeRead, http.HandlerFunc(app.apiSnippetList))))))
+	router.Handler(http.MethodGet, "/api/v1/snippets/:id", app.sessionManager.LoadAndSave(app.authenticate(app.authenticateToken(app.requireScope(models.ScopeRead, http.HandlerFunc(app.apiSnippetView))))))
+	router.Handler(http.MethodPost, "/api/v1/snippets", app.sessionManager.LoadAndSave(app.authenticate(app.authenticateToken(app.requireAPIAuthentication(app.requireScope(models.ScopeWrite, http.HandlerFunc(app.apiSnippetCreate)))))))
+	router.Handler(http.MethodPut, "/api/v1/snippets/:id", app.sessionManager.LoadAndSave(app.authenticate(app.authenticateToken(app.requireAPIAuthentication(app.requireScope(models.ScopeWrite, http.HandlerFunc(app.apiSnippetUpdate)))))))
+	router.Handler(http.MethodDelete, "/api/v1/snippets/:id", app.sessionManager.LoadAndSave(app.authenticate(app.authenticateToken(app.requireAPIAuthentication(app.requireScope(models.ScopeWrite, http.HandlerFunc(app.apiSnippetDelete)))))))
+
+	// Plain text paste endpoint for curl. Doesn't use sessions, only API tokens
+	router.Handler(http.MethodPost, "/", app.authenticateToken(app.requireScope(models.ScopeWrite, http.HandlerFunc(app.paste))))
+
 	router.Handler(http.MethodGet, "/static/*filepath", http.StripPrefix("/static", fileServer))
 
 	return app.recoverPanic(app.logRequest(secureHeaders(router)))
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned for signed tokens that have been tampered with or have expired
+var errInvalidSignedToken = errors.New("invalid or expired signed token")
+
+// Signs value so that it can be handed out, e.g. in an emailed link, and read
+// back with parseSignedToken until ttl has passed. purpose is signed along with
+// the value, so a token made for one purpose can't be used for another. The
+// value isn't encrypted, only protected from changes
+func (app *application) signToken(purpose, value string, ttl time.Duration) string {
+	payload := value + "|" + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(app.signature(purpose, payload))
+}
+
+// Returns the value signed by signToken for the same purpose, or
+// errInvalidSignedToken if the token isn't genuine or has expired
+func (app *application) parseSignedToken(purpose, token string) (string, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errInvalidSignedToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", errInvalidSignedToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", errInvalidSignedToken
+	}
+
+	// Check the signature before trusting anything in the payload
+	if !hmac.Equal(signature, app.signature(purpose, string(payload))) {
+		return "", errInvalidSignedToken
+	}
+
+	i := strings.LastIndexByte(string(payload), '|')
+	if i < 0 {
+		return "", errInvalidSignedToken
+	}
+	expires, err := strconv.ParseInt(string(payload[i+1:]), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", errInvalidSignedToken
+	}
+	return string(payload[:i]), nil
+}
+
+// HMAC-SHA256 of the purpose and payload with the application's secret key
+func (app *application) signature(purpose, payload string) []byte {
+	mac := hmac.New(sha256.New, app.secretKey)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedToken(t *testing.T) {
+	app := &application{secretKey: []byte("test secret key")}
+	other := &application{secretKey: []byte("another secret key")}
+
+	valid := app.signToken("verify", "42|alice@example.com", time.Hour)
+	payload, signature, _ := strings.Cut(valid, ".")
+	// The same payload with the user ID changed, keeping the old signature
+	forged := base64.RawURLEncoding.EncodeToString([]byte("1|alice@example.com|"+strings.Split(decodeTokenPayload(t, payload), "|")[2])) + "." + signature
+
+	tests := []struct {
+		name    string
+		app     *application
+		purpose string
+		token   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "Valid",
+			app:     app,
+			purpose: "verify",
+			token:   valid,
+			want:    "42|alice@example.com",
+		},
+		{
+			name:    "Other purpose",
+			app:     app,
+			purpose: "reset",
+			token:   valid,
+			wantErr: true,
+		},
+		{
+			name:    "Other key",
+			app:     other,
+			purpose: "verify",
+			token:   valid,
+			wantErr: true,
+		},
+		{
+			name:    "Expired",
+			app:     app,
+			purpose: "verify",
+			token:   app.signToken("verify", "42|alice@example.com", -time.Minute),
+			wantErr: true,
+		},
+		{
+			name:    "Changed payload",
+			app:     app,
+			purpose: "verify",
+			token:   forged,
+			wantErr: true,
+		},
+		{
+			name:    "No signature",
+			app:     app,
+			purpose: "verify",
+			token:   payload,
+			wantErr: true,
+		},
+		{
+			name:    "Bad encoding",
+			app:     app,
+			purpose: "verify",
+			token:   "!!!." + signature,
+			wantErr: true,
+		},
+		{
+			name:    "Empty",
+			app:     app,
+			purpose: "verify",
+			token:   "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.app.parseSignedToken(tt.purpose, tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidSignedToken) {
+					t.Errorf("got (%q, %v), want error %v", got, err, errInvalidSignedToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Decodes the payload half of a signed token
+func decodeTokenPayload(t *testing.T, s string) string {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
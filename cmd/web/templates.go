@@ -3,15 +3,25 @@ package main
 import (
 	"html/template"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/dwang288/snippetbox/internal/diff"
 	"github.com/dwang288/snippetbox/internal/models"
 )
 
 // Global var to hold the functions we want to pass into our templates
 // String to function lookup of our functions
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":       humanDate,
+	"markQuery":       markQuery,
+	"excerpt":         excerpt,
+	"highlight":       highlight,
+	"languageOptions": languageOptions,
+	"markdown":        renderMarkdown,
+	"snippetFilename": snippetFilename,
 }
 
 // Define a templateData type to act as the holding structure for
@@ -21,12 +31,48 @@ var functions = template.FuncMap{
 type templateData struct {
 	CurrentYear int
 	Snippet     *models.Snippet
+	Parent      *models.Snippet // Snippet that Snippet was forked from
 	Snippets    []*models.Snippet
 	User        *models.User
+	Revisions   []*models.Revision
+	Tokens      []*models.Token
+	NewToken    string // Plaintext of a token that was just created
+	Diff        *revisionDiff
+	Pagination  *pagination
+	Sort        string // Sort order of a snippet listing
+	Query       string // Search query, also shown in the nav search box
+	Tag         string // Tag that a snippet listing is filtered by
+	LastView    bool   // The snippet was deleted after this view used its last one
+	// Two-factor authentication setup: the secret of the app being set up,
+	// recovery codes that were just made and how many the user has left
+	TOTPSecret        string
+	RecoveryCodes     []string
+	RecoveryCodesLeft int
 	// Form for any default form data
 	Form            any
 	Flash           string
 	IsAuthenticated bool // Mark if the current user is authenticated
+	// Name of the single sign-on provider, empty if there isn't one
+	SSOName string
+	// ID of the authenticated user, 0 if nobody is logged in
+	AuthenticatedUserID int
+}
+
+// Holds the two versions of a snippet being compared and the hunks of the
+// unified diff between them
+type revisionDiff struct {
+	From  *models.Revision
+	To    *models.Revision
+	Hunks []diff.Hunk
+	// Set instead of Hunks when the versions are too big to compare
+	TooLarge bool
+}
+
+// Links to the pages on either side of the current page of a listing. A link
+// is left empty when there's no page in that direction
+type pagination struct {
+	Prev string
+	Next string
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -79,3 +125,71 @@ func newTemplateCache() (map[string]*template.Template, error) {
 func humanDate(t time.Time) string {
 	return t.Format("02 Jan 2006 at 15:04")
 }
+
+// Builds a case insensitive regex matching any of the words in a search query.
+// Returns nil if the query has no words
+func queryRX(query string) *regexp.Regexp {
+	// Drop the characters MySQL treats as full-text search operators
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return strings.ContainsRune(" \t\n\"+-<>()~*@", r)
+	})
+	if len(words) == 0 {
+		return nil
+	}
+	for i := range words {
+		words[i] = regexp.QuoteMeta(words[i])
+	}
+	return regexp.MustCompile("(?i)" + strings.Join(words, "|"))
+}
+
+// Escapes text for HTML and wraps every word of the search query in a <mark> tag
+func markQuery(text, query string) template.HTML {
+	rx := queryRX(query)
+	if rx == nil {
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range rx.FindAllStringIndex(text, -1) {
+		b.WriteString(template.HTMLEscapeString(text[last:match[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[match[0]:match[1]]))
+		b.WriteString("</mark>")
+		last = match[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+
+	return template.HTML(b.String())
+}
+
+// Number of characters shown on either side of the first match in an excerpt
+const excerptRadius = 80
+
+// Returns the part of text around the first word of the search query that it
+// contains, with the matches marked like markQuery
+func excerpt(text, query string) template.HTML {
+	runes := []rune(text)
+	start := 0
+	if rx := queryRX(query); rx != nil {
+		if loc := rx.FindStringIndex(text); loc != nil {
+			start = utf8.RuneCountInString(text[:loc[0]]) - excerptRadius
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + 2*excerptRadius
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	fragment := string(runes[start:end])
+	if start > 0 {
+		fragment = "…" + fragment
+	}
+	if end < len(runes) {
+		fragment += "…"
+	}
+	return markQuery(fragment, query)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/dwang288/snippetbox/internal/ipaddr"
+	"github.com/dwang288/snippetbox/internal/models"
+)
+
+// Failures are forgotten once there haven't been any for this long
+const loginFailureWindow = time.Hour
+
+// How failed logins are slowed down. After a few free failures, each one
+// doubles how long the next login has to wait, up to maxDelay. After
+// lockoutAfter failures logins are blocked for the whole lockout
+type loginPolicy struct {
+	free         int
+	maxDelay     time.Duration
+	lockoutAfter int
+	lockout      time.Duration
+}
+
+// An IP address gets more failures than an email address, since a whole
+// office can share one
+var loginPolicies = map[string]loginPolicy{
+	models.LoginFailureEmail: {free: 3, maxDelay: time.Minute, lockoutAfter: 10, lockout: 15 * time.Minute},
+	models.LoginFailureIP:    {free: 20, maxDelay: time.Minute, lockoutAfter: 100, lockout: 15 * time.Minute},
+}
+
+// Returns how long logins are blocked for after this many failures
+func (p loginPolicy) delay(failures int) time.Duration {
+	if failures >= p.lockoutAfter {
+		return p.lockout
+	}
+	if failures < p.free {
+		return 0
+	}
+	// Shifting too far would overflow, but the delay is capped long before
+	shift := failures - p.free
+	if shift > 30 {
+		return p.maxDelay
+	}
+	d := time.Second << shift
+	if d > p.maxDelay {
+		return p.maxDelay
+	}
+	return d
+}
+
+// Counts a login attempt against the email address and the IP address before
+// the password or code is checked, and blocks them as the policies say if it
+// fails. ip is the key from ipaddr.Network, so a client can't get fresh
+// attempts by moving around its IPv6 network. Returns nil and how long the
+// user has to wait if they're blocked
+func (app *application) attemptLogin(email, ip string) (*models.LoginAttempt, time.Duration, error) {
+	return app.loginFailures.Attempt(email, ip, loginFailureWindow, func(kind string, failures int) time.Duration {
+		return loginPolicies[kind].delay(failures)
+	})
+}
+
+// Takes back an attempt that turned out right. Once the user is logged in, the
+// earlier failures for the email address were probably typos and are
+// forgotten. The IP address's failures aren't, or logging in to one account
+// would let someone keep guessing the passwords of others
+func (app *application) loginSucceeded(attempt *models.LoginAttempt, loggedIn bool) error {
+	if loggedIn {
+		_, err := app.loginFailures.Clear(models.LoginFailureEmail, attempt.Email)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := app.loginFailures.Forgive(attempt, models.LoginFailureEmail)
+		if err != nil {
+			return err
+		}
+	}
+	return app.loginFailures.Forgive(attempt, models.LoginFailureIP)
+}
+
+// Returned by checkPassword while the user's email or IP address is blocked
+var errLoginBlocked = errors.New("too many failed logins")
+
+// Checks the password of a user who is logged in already, e.g. before turning
+// two-factor authentication off, under the same limits as logging in. A stolen
+// session could otherwise guess the password as fast as it likes. Returns
+// models.ErrInvalidCredentials if the password is wrong, or errLoginBlocked if
+// it wasn't checked. Either way the wait is how long the user is blocked for
+func (app *application) checkPassword(r *http.Request, id int, password string) (time.Duration, error) {
+	user, err := app.users.Get(id)
+	if err != nil {
+		return 0, err
+	}
+	attempt, wait, err := app.attemptLogin(user.Email, ipaddr.Network(clientIP(r)))
+	if err != nil {
+		return 0, err
+	}
+	if attempt == nil {
+		return wait, errLoginBlocked
+	}
+
+	err = app.users.CheckPassword(id, password)
+	if err != nil {
+		return attempt.Wait, err
+	}
+
+	// The email address's failures are kept, like when a password is right
+	// but a two-factor code is still needed. Knowing the password mustn't
+	// clear the way for guessing codes
+	return 0, app.loginSucceeded(attempt, false)
+}
+
+// Returns a wait like "5 seconds" or "15 minutes", rounded up so users who
+// take it literally don't get blocked again
+func humanDuration(d time.Duration) string {
+	if d < time.Minute {
+		seconds := int(math.Ceil(d.Seconds()))
+		if seconds <= 1 {
+			return "1 second"
+		}
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+
+	minutes := int(math.Ceil(d.Minutes()))
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginPolicyDelay(t *testing.T) {
+	policy := loginPolicy{free: 3, maxDelay: time.Minute, lockoutAfter: 10, lockout: 15 * time.Minute}
+
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{name: "No failures", failures: 0, want: 0},
+		{name: "Last free failure", failures: 2, want: 0},
+		{name: "First delayed failure", failures: 3, want: time.Second},
+		{name: "Doubled", failures: 4, want: 2 * time.Second},
+		{name: "Doubled again", failures: 5, want: 4 * time.Second},
+		{name: "Capped", failures: 9, want: time.Minute},
+		{name: "Locked out", failures: 10, want: 15 * time.Minute},
+		{name: "Still locked out", failures: 1000, want: 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := policy.delay(tt.failures)
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Shifting past the size of a duration would overflow into a short delay
+	policy.lockoutAfter = 1 << 30
+	if got := policy.delay(100); got != time.Minute {
+		t.Errorf("got %v for 100 failures, want %v", got, time.Minute)
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		d    time.Duration
+		want string
+	}{
+		{name: "Under a second", d: 200 * time.Millisecond, want: "1 second"},
+		{name: "Seconds", d: 5 * time.Second, want: "5 seconds"},
+		{name: "Seconds rounded up", d: 4100 * time.Millisecond, want: "5 seconds"},
+		{name: "A minute", d: time.Minute, want: "1 minute"},
+		{name: "Minutes rounded up", d: 14*time.Minute + time.Second, want: "15 minutes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := humanDuration(tt.d)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
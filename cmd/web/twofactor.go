@@ -0,0 +1,414 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dwang288/snippetbox/internal/ipaddr"
+	"github.com/dwang288/snippetbox/internal/models"
+	"github.com/dwang288/snippetbox/internal/totp"
+	"github.com/dwang288/snippetbox/internal/validator"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Name authenticator apps show next to the user's codes
+const totpIssuer = "Snippetbox"
+
+// How long users have to enter their code after their password, and how many
+// wrong codes they can enter before having to start over
+const (
+	twoFactorLoginTTL         = 5 * time.Minute
+	maxTwoFactorLoginAttempts = 5
+)
+
+// Form for the second step of logging in. Takes a code from the authenticator
+// app or one of the recovery codes
+type twoFactorLoginForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// Form for confirming a new authenticator app with one of its codes
+type twoFactorEnableForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// Form for turning two-factor authentication off, which needs the password
+type twoFactorDisableForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// Starts the second step of logging in for a user whose password was right.
+// The user is only remembered as half logged in, authenticatedUserID is set
+// once they've entered a code
+func (app *application) startTwoFactorLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "twoFactorUserID", user.ID)
+	app.sessionManager.Put(r.Context(), "sessionGeneration", user.SessionGeneration)
+	app.sessionManager.Put(r.Context(), "twoFactorExpires", time.Now().Add(twoFactorLoginTTL).Unix())
+	app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+
+	http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+}
+
+// Returns the ID of the user who is half way through logging in, or 0 if
+// nobody is, they took too long or they've been logged out everywhere since
+func (app *application) twoFactorLoginUserID(r *http.Request) (int, error) {
+	expires := app.sessionManager.GetInt64(r.Context(), "twoFactorExpires")
+	if time.Now().Unix() > expires {
+		return 0, nil
+	}
+	id := app.sessionManager.GetInt(r.Context(), "twoFactorUserID")
+	if id == 0 {
+		return 0, nil
+	}
+
+	generation := app.sessionManager.GetInt(r.Context(), "sessionGeneration")
+	loggedIn, err := app.users.LoggedIn(id, generation)
+	if err != nil || !loggedIn {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Forgets about a login that was half way through
+func (app *application) endTwoFactorLogin(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorExpires")
+	app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+}
+
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, err := app.twoFactorLoginUserID(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if id == 0 {
+		app.sessionManager.Put(r.Context(), "flash", "Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = twoFactorLoginForm{}
+	app.render(w, http.StatusOK, "login2fa.tmpl.html", data)
+}
+
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	id, err := app.twoFactorLoginUserID(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if id == 0 {
+		app.endTwoFactorLogin(r)
+		app.sessionManager.Put(r.Context(), "flash", "Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorLoginForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login2fa.tmpl.html", data)
+		return
+	}
+
+	// Codes count towards the same limits as passwords, or someone who knows
+	// the password could log in again for more guesses every few codes
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	attempt, wait, err := app.attemptLogin(user.Email, ipaddr.Network(clientIP(r)))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if attempt == nil {
+		form.AddNonFieldError(fmt.Sprintf("Too many failed login attempts. Please try again in %s.", humanDuration(wait)))
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusTooManyRequests, "login2fa.tmpl.html", data)
+		return
+	}
+
+	// Six digits are a code from the app, anything else a recovery code
+	code := strings.ReplaceAll(strings.TrimSpace(form.Code), " ", "")
+	recovery := len(code) != totp.Digits
+	var ok bool
+	if recovery {
+		ok, err = app.users.UseRecoveryCode(id, code)
+	} else {
+		ok, err = app.checkTOTP(id, code)
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !ok {
+		// Codes are short, so only a few guesses are allowed per password check
+		// too
+		attempts := app.sessionManager.GetInt(r.Context(), "twoFactorAttempts") + 1
+		if attempts >= maxTwoFactorLoginAttempts {
+			app.endTwoFactorLogin(r)
+			app.sessionManager.Put(r.Context(), "flash", "Too many incorrect codes. Please log in again.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "twoFactorAttempts", attempts)
+
+		if attempt.Wait > 0 {
+			form.AddFieldError("code", fmt.Sprintf("This code is incorrect or has already been used. Too many failed login attempts, please try again in %s.", humanDuration(attempt.Wait)))
+		} else {
+			form.AddFieldError("code", "This code is incorrect or has already been used")
+		}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login2fa.tmpl.html", data)
+		return
+	}
+
+	app.endTwoFactorLogin(r)
+	err = app.loginSucceeded(attempt, true)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Remind users who are running out of recovery codes
+	if recovery {
+		left, err := app.users.RecoveryCodesLeft(id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You used a recovery code, you have %d left.", left))
+	}
+
+	app.logIn(w, r, id)
+}
+
+// Checks a code from the user's authenticator app. A code is only accepted
+// once, even though it stays valid for a while
+func (app *application) checkTOTP(id int, code string) (bool, error) {
+	secret, err := app.users.TOTPSecret(id)
+	if err != nil || secret == nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return app.users.UseTOTPStep(id, step)
+}
+
+// Page for turning two-factor authentication on and off. While it's off, the
+// secret of the app being set up is kept in the session until it's confirmed
+func (app *application) accountTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+
+	if user.TwoFactor {
+		data.RecoveryCodesLeft, err = app.users.RecoveryCodesLeft(user.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		// New recovery codes are shown once, right after turning 2FA on
+		data.RecoveryCodes, _ = app.sessionManager.Pop(r.Context(), "recoveryCodes").([]string)
+		data.Form = twoFactorDisableForm{}
+		app.render(w, http.StatusOK, "twofactor.tmpl.html", data)
+		return
+	}
+
+	secret, err := app.pendingTOTPSecret(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data.TOTPSecret = totp.EncodeSecret(secret)
+	data.Form = twoFactorEnableForm{}
+	app.render(w, http.StatusOK, "twofactor.tmpl.html", data)
+}
+
+// Returns the secret of the authenticator app being set up, making one up the
+// first time
+func (app *application) pendingTOTPSecret(r *http.Request) ([]byte, error) {
+	if s := app.sessionManager.GetString(r.Context(), "totpSecret"); s != "" {
+		return totp.DecodeSecret(s)
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	app.sessionManager.Put(r.Context(), "totpSecret", totp.EncodeSecret(secret))
+	return secret, nil
+}
+
+// Serves the QR code of the secret being set up as a PNG. It's a separate URL
+// because the Content-Security-Policy doesn't allow data: images
+func (app *application) accountTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	s := app.sessionManager.GetString(r.Context(), "totpSecret")
+	if s == "" {
+		app.notFound(w)
+		return
+	}
+	secret, err := totp.DecodeSecret(s)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	png, err := qrcode.Encode(totp.URI(totpIssuer, user.Email, secret), qrcode.Medium, 256)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+func (app *application) accountTwoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	id := app.authenticatedUserID(r)
+
+	var form twoFactorEnableForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The secret is gone if the session was renewed, start the setup again
+	s := app.sessionManager.GetString(r.Context(), "totpSecret")
+	if s == "" {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+	secret, err := totp.DecodeSecret(s)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Asking for a code proves the app was set up right before it's required
+	step, ok := totp.Validate(secret, form.Code, time.Now())
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	form.CheckField(ok, "code", "This code is incorrect, check the time on your device is right")
+	if !form.Valid() {
+		user, err := app.users.Get(id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.User = user
+		data.TOTPSecret = s
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "twofactor.tmpl.html", data)
+		return
+	}
+
+	codes, err := app.users.EnableTwoFactor(id, secret, step)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "totpSecret")
+	app.sessionManager.Put(r.Context(), "recoveryCodes", codes)
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication is now on.")
+	http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+}
+
+func (app *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	id := app.authenticatedUserID(r)
+
+	var form twoFactorDisableForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	if form.Valid() {
+		wait, err := app.checkPassword(r, id, form.Password)
+		switch {
+		case errors.Is(err, errLoginBlocked):
+			form.AddFieldError("password", fmt.Sprintf("Too many failed attempts. Please try again in %s.", humanDuration(wait)))
+		case errors.Is(err, models.ErrInvalidCredentials) && wait > 0:
+			form.AddFieldError("password", fmt.Sprintf("Password is incorrect. Too many failed attempts, please try again in %s.", humanDuration(wait)))
+		case errors.Is(err, models.ErrInvalidCredentials):
+			form.AddFieldError("password", "Password is incorrect")
+		case err != nil:
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		user, err := app.users.Get(id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.User = user
+		data.RecoveryCodesLeft, err = app.users.RecoveryCodesLeft(id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "twofactor.tmpl.html", data)
+		return
+	}
+
+	err = app.users.DisableTwoFactor(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication is now off.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
@@ -8,8 +8,25 @@ require (
 )
 
 require (
+	github.com/alecthomas/chroma/v2 v2.9.1
 	github.com/alexedwards/scs/mysqlstore v0.0.0-20230327161757-10d4299e3b24
 	github.com/alexedwards/scs/v2 v2.5.1
+	github.com/coreos/go-oidc/v3 v3.9.0
+	github.com/go-jose/go-jose/v3 v3.0.4
 	github.com/go-playground/form/v4 v4.2.1
-	golang.org/x/crypto v0.13.0
+	github.com/microcosm-cc/bluemonday v1.0.25
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	github.com/yuin/goldmark v1.5.6
+	golang.org/x/crypto v0.19.0
+	golang.org/x/oauth2 v0.13.0
+)
+
+require (
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/dlclark/regexp2 v1.10.0 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/gorilla/css v1.0.0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	google.golang.org/appengine v1.6.8 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
 )
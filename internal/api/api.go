@@ -0,0 +1,68 @@
+// Package api holds the request and response bodies of the JSON API served
+// under /api/v1. The server and the snip command line client both use these
+// types, so they can't drift apart
+package api
+
+import (
+	"time"
+
+	"github.com/dwang288/snippetbox/internal/models"
+)
+
+// Body of requests that create or update a snippet. Field names match the names
+// of the HTML form fields, so validation errors use the same keys as the form.
+// Updates keep the current value of every field they leave out, the defaults
+// below are for new snippets
+type SnippetRequest struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// Number of days until the snippet expires, never or keep. Defaults to 365
+	// for new snippets and keep for updates, or custom if ExpiresAt is set
+	Expires   string     `json:"expires,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	// Left blank to have the language detected from the content
+	Language string `json:"language,omitempty"`
+	// Default to code and public
+	Format     string `json:"format,omitempty"`
+	Visibility string `json:"visibility,omitempty"`
+	MaxViews   int    `json:"maxViews,omitempty"`
+	// Burning a snippet after reading is the same as a limit of one view
+	BurnAfterReading bool                  `json:"burn,omitempty"`
+	Files            []*models.SnippetFile `json:"files,omitempty"`
+}
+
+// Response to reading, creating or updating a snippet
+type SnippetResponse struct {
+	Snippet *models.Snippet `json:"snippet"`
+}
+
+// Response to listing snippets. Prev and Next are the URLs of the pages on
+// either side, nil when there's no such page
+type SnippetListResponse struct {
+	Snippets []*models.Snippet `json:"snippets"`
+	Prev     *string           `json:"prev"`
+	Next     *string           `json:"next"`
+}
+
+// The authenticated user, without anything secret like their password hash
+type Account struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Response to reading the account. Scopes lists what the API token used for
+// the request may do, and is left out for browser sessions
+type AccountResponse struct {
+	Account *Account `json:"account"`
+	Scopes  []string `json:"scopes,omitempty"`
+}
+
+// Body of every error response. Validation errors also list what's wrong with
+// each field, keyed by the field's JSON name
+type ErrorResponse struct {
+	Error          string            `json:"error"`
+	FieldErrors    map[string]string `json:"fieldErrors,omitempty"`
+	NonFieldErrors []string          `json:"nonFieldErrors,omitempty"`
+}
@@ -0,0 +1,21 @@
+// Package cli holds helpers shared by the command line tools, snip and admin
+package cli
+
+import "flag"
+
+// ParseArgs parses the flags of a command and returns its other arguments.
+// Unlike fs.Parse, flags can come after the arguments too, e.g. snip get 12 -json
+func ParseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantArgs []string
+		wantJSON bool
+		wantName string
+		wantErr  bool
+	}{
+		{
+			name:     "Nothing",
+			wantArgs: []string{},
+		},
+		{
+			name:     "Flags first",
+			args:     []string{"-json", "-name", "x", "a", "b"},
+			wantArgs: []string{"a", "b"},
+			wantJSON: true,
+			wantName: "x",
+		},
+		{
+			name:     "Flags last",
+			args:     []string{"a", "b", "-json", "-name=x"},
+			wantArgs: []string{"a", "b"},
+			wantJSON: true,
+			wantName: "x",
+		},
+		{
+			name:     "Flags in between",
+			args:     []string{"a", "-name", "x", "b"},
+			wantArgs: []string{"a", "b"},
+			wantName: "x",
+		},
+		{
+			name:     "Arguments after --",
+			args:     []string{"a", "--", "-json"},
+			wantArgs: []string{"a", "-json"},
+		},
+		{
+			name:    "Unknown flag",
+			args:    []string{"a", "-nope"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			json := fs.Bool("json", false, "")
+			name := fs.String("name", "", "")
+
+			got, err := ParseArgs(fs, tt.args)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.wantArgs) {
+				t.Errorf("got arguments %q, want %q", got, tt.wantArgs)
+			}
+			if *json != tt.wantJSON || *name != tt.wantName {
+				t.Errorf("got -json=%t -name=%q, want -json=%t -name=%q", *json, *name, tt.wantJSON, tt.wantName)
+			}
+		})
+	}
+}
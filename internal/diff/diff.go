@@ -0,0 +1,317 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxLines is the most lines Unified compares, counting both texts. The time a
+// diff takes grows with the number of lines times the number of changes, so
+// bigger texts aren't compared at all
+const MaxLines = 10000
+
+// ErrTooLarge is returned by Unified for texts with more than MaxLines lines
+var ErrTooLarge = errors.New("diff: too many lines to compare")
+
+// Op describes what happened to a line when going from the old text to the new one
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Line is a single line of a diff along with its line numbers in the old (A)
+// and new (B) text. A line number is 0 when the line doesn't exist on that side
+type Line struct {
+	Op    Op
+	Text  string
+	LineA int
+	LineB int
+}
+
+// Prefix returns the character unified diffs put in front of the line
+func (l Line) Prefix() string {
+	switch l.Op {
+	case Delete:
+		return "-"
+	case Insert:
+		return "+"
+	default:
+		return " "
+	}
+}
+
+// Hunk is a group of changed lines surrounded by unchanged context lines
+type Hunk struct {
+	StartA, CountA int
+	StartB, CountB int
+	Lines          []Line
+}
+
+// Header returns the "@@ -a,b +c,d @@" line that introduces the hunk
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.StartA, h.CountA, h.StartB, h.CountB)
+}
+
+// Unified compares a and b line by line and returns the hunks of a unified diff,
+// with up to context unchanged lines around every change. Returns nil if the
+// texts are the same, and ErrTooLarge if they have more than MaxLines lines
+func Unified(a, b string, context int) ([]Hunk, error) {
+	linesA, linesB := splitLines(a), splitLines(b)
+	if len(linesA)+len(linesB) > MaxLines {
+		return nil, ErrTooLarge
+	}
+	lines := Lines(linesA, linesB)
+
+	var hunks []Hunk
+	for i := 0; i < len(lines); {
+		if lines[i].Op == Equal {
+			i++
+			continue
+		}
+
+		// Extend the hunk over every following change that is separated from
+		// the last one by no more than 2*context unchanged lines, since the
+		// context of both would overlap otherwise
+		end := i + 1
+		for j := end; j < len(lines); j++ {
+			if lines[j].Op != Equal {
+				end = j + 1
+			} else if j-end+1 > 2*context {
+				break
+			}
+		}
+
+		// Surround the changes with up to context unchanged lines
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		stop := end + context
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+
+		h := Hunk{Lines: lines[start:stop]}
+		h.count()
+		hunks = append(hunks, h)
+		i = stop
+	}
+	return hunks, nil
+}
+
+// Fills in the start line and line counts of the hunk from its lines
+func (h *Hunk) count() {
+	for _, l := range h.Lines {
+		if l.Op != Insert {
+			if h.CountA == 0 {
+				h.StartA = l.LineA
+			}
+			h.CountA++
+		}
+		if l.Op != Delete {
+			if h.CountB == 0 {
+				h.StartB = l.LineB
+			}
+			h.CountB++
+		}
+	}
+	// Like diff(1), an empty side starts at the line before the change
+	if h.CountA == 0 {
+		h.StartA = precedingLine(h.Lines, func(l Line) int { return l.LineA })
+	}
+	if h.CountB == 0 {
+		h.StartB = precedingLine(h.Lines, func(l Line) int { return l.LineB })
+	}
+}
+
+// Returns the line number before the first line of the hunk on one side
+func precedingLine(lines []Line, number func(Line) int) int {
+	for _, l := range lines {
+		if n := number(l); n > 0 {
+			return n - 1
+		}
+	}
+	return 0
+}
+
+// Splits text into lines, ignoring a trailing newline
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Lines returns the shortest edit script that turns a into b, as the full list
+// of equal, deleted and inserted lines. Uses the linear space variant of the
+// Myers diff algorithm, which splits the texts at the middle of the edit
+// script and diffs both halves, so memory only grows with the length of the
+// texts and not with the number of changes
+func Lines(a, b []string) []Line {
+	d := &differ{a: a, b: b, lines: make([]Line, 0, len(a)+len(b))}
+	d.compare(0, len(a), 0, len(b))
+	return d.lines
+}
+
+// Holds the texts being compared and the edit script built so far
+type differ struct {
+	a, b  []string
+	lines []Line
+}
+
+func (d *differ) equal(x, y int) {
+	d.lines = append(d.lines, Line{Op: Equal, Text: d.a[x], LineA: x + 1, LineB: y + 1})
+}
+
+func (d *differ) delete(x int) {
+	d.lines = append(d.lines, Line{Op: Delete, Text: d.a[x], LineA: x + 1})
+}
+
+func (d *differ) insert(y int) {
+	d.lines = append(d.lines, Line{Op: Insert, Text: d.b[y], LineB: y + 1})
+}
+
+// Appends the edit script that turns a[aLo:aHi] into b[bLo:bHi]
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	// Lines both sides start or end with are equal, and leaving them out keeps
+	// the search below small
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.equal(aLo, bLo)
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-suffix-1] == d.b[bHi-suffix-1] {
+		suffix++
+	}
+	aEnd, bEnd := aHi-suffix, bHi-suffix
+
+	switch {
+	case aLo == aEnd:
+		for y := bLo; y < bEnd; y++ {
+			d.insert(y)
+		}
+	case bLo == bEnd:
+		for x := aLo; x < aEnd; x++ {
+			d.delete(x)
+		}
+	default:
+		x, y, ok := d.middle(aLo, aEnd, bLo, bEnd)
+		if ok {
+			d.compare(aLo, x, bLo, y)
+			d.compare(x, aEnd, y, bEnd)
+		} else {
+			// Nothing in common at all
+			for x := aLo; x < aEnd; x++ {
+				d.delete(x)
+			}
+			for y := bLo; y < bEnd; y++ {
+				d.insert(y)
+			}
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		d.equal(aEnd+i, bEnd+i)
+	}
+}
+
+// Searches for the shortest edit script from both ends of a[aLo:aHi] and
+// b[bLo:bHi] at once, and returns the point where the two searches meet,
+// which is on a shortest edit script. ok is false if the texts have no line
+// in common. Both texts must be non-empty and differ in their first and last
+// lines
+func (d *differ) middle(aLo, aHi, bLo, bHi int) (x, y int, ok bool) {
+	n, m := aHi-aLo, bHi-bLo
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+
+	// vf holds the furthest x reached on each diagonal k (x - y) searching
+	// forwards from the start, vb the same searching backwards from the end,
+	// with x counted from the end. -1 marks diagonals not reached yet
+	vf := make([]int, 2*offset+1)
+	vb := make([]int, 2*offset+1)
+	for i := range vf {
+		vf[i] = -1
+		vb[i] = -1
+	}
+	vf[offset+1] = 0
+	vb[offset+1] = 0
+
+	// The searches meet on a forward step if the lengths differ by an odd
+	// number of lines, and on a backward step otherwise
+	delta := n - m
+	forwardMeets := delta%2 != 0
+
+	// Diagonals that ran off the edge of the graph don't need searching again
+	var fStart, fEnd, bStart, bEnd int
+
+	for step := 0; step < maxD; step++ {
+		for k := -step + fStart; k <= step-fEnd; k += 2 {
+			var x int
+			if k == -step || (k != step && vf[offset+k-1] < vf[offset+k+1]) {
+				// Move down from the diagonal above, an insertion
+				x = vf[offset+k+1]
+			} else {
+				// Move right from the diagonal below, a deletion
+				x = vf[offset+k-1] + 1
+			}
+			y := x - k
+			// Follow the run of equal lines
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			vf[offset+k] = x
+
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case forwardMeets:
+				i := offset + delta - k
+				if i >= 0 && i < len(vb) && vb[i] != -1 && x >= n-vb[i] {
+					return aLo + x, bLo + y, true
+				}
+			}
+		}
+
+		for k := -step + bStart; k <= step-bEnd; k += 2 {
+			var x int
+			if k == -step || (k != step && vb[offset+k-1] < vb[offset+k+1]) {
+				x = vb[offset+k+1]
+			} else {
+				x = vb[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aHi-x-1] == d.b[bHi-y-1] {
+				x++
+				y++
+			}
+			vb[offset+k] = x
+
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !forwardMeets:
+				i := offset + delta - k
+				if i >= 0 && i < len(vf) && vf[i] != -1 {
+					fx := vf[i]
+					fy := fx - (i - offset)
+					if fx >= n-x {
+						return aLo + fx, bLo + fy, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
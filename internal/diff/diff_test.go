@@ -0,0 +1,252 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Writes an edit script the way a unified diff shows it, one line per edit
+func script(lines []Line) string {
+	var sb strings.Builder
+	for _, l := range lines {
+		fmt.Fprintf(&sb, "%s%s %d,%d\n", l.Prefix(), l.Text, l.LineA, l.LineB)
+	}
+	return sb.String()
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []string
+		b    []string
+		want string
+	}{
+		{
+			name: "Both empty",
+			want: "",
+		},
+		{
+			name: "All inserted",
+			b:    []string{"x", "y"},
+			want: "+x 0,1\n+y 0,2\n",
+		},
+		{
+			name: "All deleted",
+			a:    []string{"x", "y"},
+			want: "-x 1,0\n-y 2,0\n",
+		},
+		{
+			name: "Identical",
+			a:    []string{"x", "y"},
+			b:    []string{"x", "y"},
+			want: " x 1,1\n y 2,2\n",
+		},
+		{
+			name: "Changed line in the middle",
+			a:    []string{"a", "b", "c"},
+			b:    []string{"a", "x", "c"},
+			want: " a 1,1\n-b 2,0\n+x 0,2\n c 3,3\n",
+		},
+		{
+			name: "Nothing in common",
+			a:    []string{"a", "b"},
+			b:    []string{"x", "y", "z"},
+			want: "-a 1,0\n-b 2,0\n+x 0,1\n+y 0,2\n+z 0,3\n",
+		},
+		{
+			name: "Inserted at the start",
+			a:    []string{"b", "c"},
+			b:    []string{"a", "b", "c"},
+			want: "+a 0,1\n b 1,2\n c 2,3\n",
+		},
+		{
+			name: "Deleted at the end",
+			a:    []string{"a", "b", "c"},
+			b:    []string{"a", "b"},
+			want: " a 1,1\n b 2,2\n-c 3,0\n",
+		},
+		{
+			name: "Moved line",
+			a:    []string{"a", "b", "c", "d"},
+			b:    []string{"b", "c", "d", "a"},
+			want: "-a 1,0\n b 2,1\n c 3,2\n d 4,3\n+a 0,4\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := script(Lines(tt.a, tt.b))
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Returns the length of the longest common subsequence of a and b
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else if prev[j+1] > cur[j] {
+				cur[j+1] = prev[j+1]
+			} else {
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// Checks random texts against a slow but simple LCS: the edit script has to
+// turn a into b and can't have more edits than needed
+func TestLinesShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	alphabet := []string{"a", "b", "c", "d"}
+	random := func() []string {
+		lines := make([]string, rng.Intn(12))
+		for i := range lines {
+			lines[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		return lines
+	}
+
+	for i := 0; i < 2000; i++ {
+		a, b := random(), random()
+		lines := Lines(a, b)
+
+		var gotA, gotB []string
+		edits := 0
+		for _, l := range lines {
+			if l.Op != Insert {
+				if l.LineA != len(gotA)+1 {
+					t.Fatalf("Lines(%q, %q): line %q has LineA %d, want %d", a, b, l.Text, l.LineA, len(gotA)+1)
+				}
+				gotA = append(gotA, l.Text)
+			}
+			if l.Op != Delete {
+				if l.LineB != len(gotB)+1 {
+					t.Fatalf("Lines(%q, %q): line %q has LineB %d, want %d", a, b, l.Text, l.LineB, len(gotB)+1)
+				}
+				gotB = append(gotB, l.Text)
+			}
+			if l.Op != Equal {
+				edits++
+			}
+		}
+
+		if strings.Join(gotA, "\n") != strings.Join(a, "\n") || strings.Join(gotB, "\n") != strings.Join(b, "\n") {
+			t.Fatalf("Lines(%q, %q) doesn't turn one into the other:\n%s", a, b, script(lines))
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); edits != want {
+			t.Fatalf("Lines(%q, %q) has %d edits, want %d:\n%s", a, b, edits, want, script(lines))
+		}
+	}
+}
+
+// Two texts with nothing in common are the worst case. Memory used to grow
+// with the number of lines times the number of changes
+func TestLinesMemory(t *testing.T) {
+	a := make([]string, 3000)
+	b := make([]string, 3000)
+	for i := range a {
+		a[i] = fmt.Sprintf("a%d", i)
+		b[i] = fmt.Sprintf("b%d", i)
+	}
+
+	result := testing.Benchmark(func(tb *testing.B) {
+		tb.ReportAllocs()
+		for i := 0; i < tb.N; i++ {
+			Lines(a, b)
+		}
+	})
+	if bytes := result.AllocedBytesPerOp(); bytes > 10<<20 {
+		t.Errorf("allocated %d bytes, want at most %d", bytes, 10<<20)
+	}
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       string
+		b       string
+		context int
+		want    []string
+	}{
+		{
+			name:    "Identical",
+			a:       "a\nb\n",
+			b:       "a\nb",
+			context: 3,
+			want:    nil,
+		},
+		{
+			name:    "Single change",
+			a:       "a\nb\nc\nd\ne\n",
+			b:       "a\nb\nx\nd\ne\n",
+			context: 1,
+			want:    []string{"@@ -2,3 +2,3 @@"},
+		},
+		{
+			name:    "Changes far apart",
+			a:       "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:       "x\n2\n3\n4\n5\n6\n7\ny\n",
+			context: 1,
+			want:    []string{"@@ -1,2 +1,2 @@", "@@ -7,2 +7,2 @@"},
+		},
+		{
+			name:    "Changes with overlapping context",
+			a:       "1\n2\n3\n4\n5\n",
+			b:       "x\n2\n3\n4\ny\n",
+			context: 2,
+			want:    []string{"@@ -1,5 +1,5 @@"},
+		},
+		{
+			name:    "Added to empty text",
+			a:       "",
+			b:       "a\nb\n",
+			context: 3,
+			want:    []string{"@@ -0,0 +1,2 @@"},
+		},
+		{
+			name:    "Deleted everything",
+			a:       "a\nb\n",
+			b:       "",
+			context: 3,
+			want:    []string{"@@ -1,2 +0,0 @@"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hunks, err := Unified(tt.a, tt.b, tt.context)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, h := range hunks {
+				got = append(got, h.Header())
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got hunks %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedTooLarge(t *testing.T) {
+	big := strings.Repeat("line\n", MaxLines/2+1)
+
+	_, err := Unified(big, big, 3)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got error %v, want %v", err, ErrTooLarge)
+	}
+}
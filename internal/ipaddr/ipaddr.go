@@ -0,0 +1,18 @@
+// Package ipaddr groups client IP addresses for rate limits and login
+// throttling
+package ipaddr
+
+import "net"
+
+// Network returns the key a client at ip is counted under. IPv4 addresses are
+// counted on their own, but IPv6 users usually get a whole /64 network, so
+// all of its addresses share a key. Anything that isn't an IP address is
+// returned as it is
+func Network(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() != nil {
+		return ip
+	}
+	network := net.IPNet{IP: addr.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+	return network.String()
+}
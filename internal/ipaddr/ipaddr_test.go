@@ -0,0 +1,56 @@
+package ipaddr
+
+import "testing"
+
+func TestNetwork(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{
+			name: "IPv4",
+			ip:   "192.0.2.7",
+			want: "192.0.2.7",
+		},
+		{
+			name: "IPv4 mapped to IPv6",
+			ip:   "::ffff:192.0.2.7",
+			want: "::ffff:192.0.2.7",
+		},
+		{
+			name: "IPv6",
+			ip:   "2001:db8:1:2:3:4:5:6",
+			want: "2001:db8:1:2::/64",
+		},
+		{
+			name: "Same IPv6 network",
+			ip:   "2001:db8:1:2:ffff:ffff:ffff:ffff",
+			want: "2001:db8:1:2::/64",
+		},
+		{
+			name: "Already a network",
+			ip:   "2001:db8:1:2::/64",
+			want: "2001:db8:1:2::/64",
+		},
+		{
+			name: "Loopback",
+			ip:   "::1",
+			want: "::/64",
+		},
+		{
+			name: "Not an address",
+			ip:   "@",
+			want: "@",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Network(tt.ip)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Package mailer sends the emails the application needs, like verification
+// links. Messages go through the Mailer interface, so that development setups
+// and tests can write them to a log instead of needing a real mail server
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A plain text email to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// SMTP sends messages through an SMTP server. The connection is upgraded with
+// STARTTLS whenever the server supports it
+type SMTP struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+// NewSMTP returns a Mailer that sends messages from sender through the server
+// at host:port. The username and password are left empty for servers that
+// don't need authentication
+func NewSMTP(host string, port int, username, password, sender string) (*SMTP, error) {
+	// Catch a badly written sender address now rather than on the first email
+	_, err := mail.ParseAddress(sender)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: sender: %w", err)
+	}
+
+	m := &SMTP{
+		addr:   net.JoinHostPort(host, strconv.Itoa(port)),
+		sender: sender,
+	}
+	// PlainAuth refuses to send the password unless the connection is
+	// encrypted or goes to localhost
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m, nil
+}
+
+// Send delivers the message to the SMTP server
+func (m *SMTP) Send(msg *Message) error {
+	from, err := mail.ParseAddress(m.sender)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	b, err := format(m.sender, msg, true)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, from.Address, []string{to.Address}, b)
+}
+
+// Log writes messages to w instead of sending them, for development and for
+// trying the application out without a mail server. Links in the messages can
+// be copied out of the log
+type Log struct {
+	sender string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLog returns a Mailer that writes messages from sender to w
+func NewLog(w io.Writer, sender string) *Log {
+	return &Log{w: w, sender: sender}
+}
+
+// Send writes the message to the log, followed by a blank line. The body is
+// written as is, so that long links aren't wrapped
+func (m *Log) Send(msg *Message) error {
+	b, err := format(m.sender, msg, false)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err = fmt.Fprintf(m.w, "%s\r\n", b)
+	return err
+}
+
+// Formats the message as an RFC 5322 email with a UTF-8 body, which is
+// quoted-printable encoded if encode is set
+func format(sender string, msg *Message, encode bool) ([]byte, error) {
+	var buf bytes.Buffer
+
+	// A line break in a header would let the rest of it add headers of its own
+	if strings.ContainsAny(sender+msg.To, "\r\n") {
+		return nil, errors.New("mailer: line break in address")
+	}
+
+	fmt.Fprintf(&buf, "From: %s\r\n", sender)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	if !encode {
+		buf.WriteString("\r\n")
+		buf.WriteString(msg.Body)
+		return buf.Bytes(), nil
+	}
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	_, err := qp.Write([]byte(msg.Body))
+	if err != nil {
+		return nil, err
+	}
+	err = qp.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
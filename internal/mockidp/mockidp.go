@@ -0,0 +1,324 @@
+// Package mockidp is a tiny OpenID Connect identity provider for trying out
+// and testing single sign-on. It isn't secure and mustn't be used for anything
+// real: whoever opens the login page can be whoever they like
+package mockidp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+// How long an authorization code can be traded for tokens, and how long the
+// tokens last
+const (
+	codeTTL  = time.Minute
+	tokenTTL = time.Hour
+)
+
+// What the authorization endpoint remembers about a login until the client
+// trades the code for tokens
+type authorization struct {
+	redirectURI   string
+	nonce         string
+	challenge     string
+	subject       string
+	email         string
+	emailVerified bool
+	name          string
+	expires       time.Time
+}
+
+// Provider is an identity provider with a single client
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+	keyID        string
+	signer       jose.Signer
+	infoLog      *log.Logger
+
+	mu    sync.Mutex
+	codes map[string]*authorization
+}
+
+// New returns a provider reached at the issuer URL, for the client with this
+// ID and secret. It signs its ID tokens with a new key every time, clients
+// fetch it from the JWKS endpoint
+func New(issuer, clientID, clientSecret string, infoLog *log.Logger) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	keyID := randomString()
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", keyID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		key:          key,
+		keyID:        keyID,
+		signer:       signer,
+		infoLog:      infoLog,
+		codes:        make(map[string]*authorization),
+	}, nil
+}
+
+// Routes returns the provider's endpoints. /authorize shows a login page where
+// the user fills in the claims of their ID token
+func (p *Provider) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	return mux
+}
+
+// Returns a random string for codes and tokens
+func randomString() string {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Token endpoint errors, as described in RFC 6749 section 5.2
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// The discovery document tells clients where everything else is
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"claims_supported":                      []string{"sub", "email", "email_verified", "name"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       &p.key.PublicKey,
+		KeyID:     p.keyID,
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}}})
+}
+
+// Instead of asking for a password, the login page lets the user fill in the
+// claims the ID token will have
+var loginPage = template.Must(template.New("login").Parse(`<!doctype html>
+<html lang='en'>
+<head><meta charset='utf-8'><title>Mock identity provider</title></head>
+<body>
+<h1>Mock identity provider</h1>
+<p>Log in as anyone. This is for local testing only.</p>
+<form method='post' action='/authorize'>
+    {{range $k, $v := .Params}}<input type='hidden' name='{{$k}}' value='{{index $v 0}}'>
+    {{end}}
+    <p><label>Subject <input name='sub' value='mock-user-1' required></label></p>
+    <p><label>Email <input type='email' name='email' value='alice@example.com'></label></p>
+    <p><label><input type='checkbox' name='email_verified' value='true' checked> Email verified</label></p>
+    <p><label>Name <input name='name' value='Alice'></label></p>
+    <p><button name='action' value='allow'>Log in</button> <button name='action' value='deny'>Deny</button></p>
+</form>
+</body>
+</html>
+`))
+
+// The authorization endpoint shows the login page, then sends the user back
+// to the client with a code, or an error if they denied access
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Errors about the client or redirect URI can't be sent back to it
+	if r.Form.Get("client_id") != p.clientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	redirect := func(params url.Values) {
+		params.Set("state", r.Form.Get("state"))
+		u := *redirectURI
+		u.RawQuery = params.Encode()
+		http.Redirect(w, r, u.String(), http.StatusSeeOther)
+	}
+
+	if r.Form.Get("response_type") != "code" {
+		redirect(url.Values{"error": {"unsupported_response_type"}})
+		return
+	}
+	// PKCE is required, like it will be in OAuth 2.1
+	if r.Form.Get("code_challenge_method") != "S256" || r.Form.Get("code_challenge") == "" {
+		redirect(url.Values{"error": {"invalid_request"}, "error_description": {"S256 code_challenge required"}})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		params := url.Values{}
+		for _, name := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			params.Set(name, r.Form.Get(name))
+		}
+		err = loginPage.Execute(w, map[string]any{"Params": params})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.PostForm.Get("action") != "allow" {
+		redirect(url.Values{"error": {"access_denied"}, "error_description": {"the user denied access"}})
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = &authorization{
+		redirectURI:   redirectURI.String(),
+		nonce:         r.PostForm.Get("nonce"),
+		challenge:     r.PostForm.Get("code_challenge"),
+		subject:       r.PostForm.Get("sub"),
+		email:         r.PostForm.Get("email"),
+		emailVerified: r.PostForm.Get("email_verified") == "true",
+		name:          r.PostForm.Get("name"),
+		expires:       time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+
+	p.infoLog.Printf("issued code for %q", r.PostForm.Get("sub"))
+	redirect(url.Values{"code": {code}})
+}
+
+// The token endpoint trades a code for an ID token, once the client has
+// proved who it is and that it started the login
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		tokenError(w, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	// Clients can send their credentials in a header or in the form
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.clientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	// Codes can only be used once
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	auth := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if auth == nil || time.Now().After(auth.expires) || auth.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier doesn't match code_challenge")
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":            p.issuer,
+		"sub":            auth.subject,
+		"aud":            p.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(tokenTTL).Unix(),
+		"email":          auth.email,
+		"email_verified": auth.emailVerified,
+		"name":           auth.name,
+	}
+	if auth.nonce != "" {
+		claims["nonce"] = auth.nonce
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	signed, err := p.signer.Sign(payload)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	idToken, err := signed.CompactSerialize()
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
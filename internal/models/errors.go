@@ -8,4 +8,10 @@ var (
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	// Add error for when user tries to sign up with an existing email
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+	// Add error for when a disabled user tries to log in
+	ErrDisabledUser = errors.New("models: disabled user")
+	// Add error for when a user who hasn't verified their email tries to log in
+	ErrUnverifiedUser = errors.New("models: unverified user")
+	// Add error for when a pagination cursor can't be read
+	ErrInvalidCursor = errors.New("models: invalid pagination cursor")
 )
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"database/sql"
+)
+
+// A named file in a snippet bundle. The snippet's own content is its first
+// file, these are the ones that come after it
+type SnippetFile struct {
+	Name string `json:"name"`
+	// Name of the language the content is highlighted as, empty for plain text
+	Language string `json:"language"`
+	Content  string `json:"content"`
+}
+
+// Replaces the extra files of a snippet, keeping them in the order given. Must
+// run in the same transaction as the write that saves the snippet, so that a
+// bundle is never saved with only some of its files
+func setFiles(tx *sql.Tx, snippetID int, files []*SnippetFile) error {
+	_, err := tx.Exec(`DELETE FROM snippet_files WHERE snippet_id = ?`, snippetID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO snippet_files (snippet_id, position, name, language, content)
+	VALUES (?, ?, ?, ?, ?)`
+	for i, f := range files {
+		_, err = tx.Exec(stmt, snippetID, i, f.Name, f.Language, f.Content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loads the extra files of a single snippet. Listings never show file
+// contents, so unlike tags they're only loaded for one snippet at a time
+func (m *SnippetModel) attachFiles(s *Snippet) error {
+	stmt := `SELECT name, language, content FROM snippet_files
+	WHERE snippet_id = ? ORDER BY position`
+
+	rows, err := m.DB.Query(stmt, s.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	s.Files = []*SnippetFile{}
+	for rows.Next() {
+		f := &SnippetFile{}
+		err = rows.Scan(&f.Name, &f.Language, &f.Content)
+		if err != nil {
+			return err
+		}
+		s.Files = append(s.Files, f)
+	}
+	return rows.Err()
+}
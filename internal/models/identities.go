@@ -0,0 +1,123 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GetByIdentity returns the user linked to the account with this subject at an
+// OpenID Connect identity provider
+func (m *UserModel) GetByIdentity(issuer, subject string) (*User, error) {
+	return m.get("id = (SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?)", issuer, subject)
+}
+
+// LinkIdentity links an existing user to their account at an identity
+// provider, so they can log in through it from now on. Linking an identity to
+// the user it's linked to already isn't an error, two first logins can race
+// each other, e.g. when the callback is loaded twice
+func (m *UserModel) LinkIdentity(id int, issuer, subject string) error {
+	statement := `INSERT INTO user_identities (issuer, subject, user_id, created)
+	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(statement, issuer, subject, id)
+	if err == nil || !isDuplicateKey(err) {
+		return err
+	}
+
+	linked, lookupErr := m.linkedUserID(issuer, subject)
+	if lookupErr == nil && linked == id {
+		return nil
+	}
+	return err
+}
+
+// Returns the ID of the user the identity is linked to, sql.ErrNoRows if it
+// isn't linked to anyone
+func (m *UserModel) linkedUserID(issuer, subject string) (int, error) {
+	var id int
+	statement := "SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?"
+	err := m.DB.QueryRow(statement, issuer, subject).Scan(&id)
+	return id, err
+}
+
+// Reports whether an insert failed because a row with the same unique key
+// exists already
+func isDuplicateKey(err error) bool {
+	var mySQLError *mysql.MySQLError
+	return errors.As(err, &mySQLError) && mySQLError.Number == 1062
+}
+
+// InsertWithIdentity creates a verified user for someone logging in through an
+// identity provider for the first time, and returns its ID. The user gets a
+// random password nobody knows, which they can replace with the forgotten
+// password flow if they ever want to log in without the provider.
+//
+// Two first logins through the same identity can race each other, e.g. when
+// the callback is loaded twice. The one that loses returns the ID of the user
+// the other one created instead of an error
+func (m *UserModel) InsertWithIdentity(name, email, issuer, subject string) (int, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return 0, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(base64.RawURLEncoding.EncodeToString(b)), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	statement := `INSERT INTO users (name, email, hashed_password, created, verified)
+	VALUES(?, ?, ?, UTC_TIMESTAMP(), TRUE)`
+	result, err := tx.Exec(statement, name, email, string(hashedPassword))
+	if err != nil {
+		if isDuplicateEmail(err) {
+			return m.raceWinner(tx, issuer, subject, ErrDuplicateEmail)
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	statement = `INSERT INTO user_identities (issuer, subject, user_id, created)
+	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	_, err = tx.Exec(statement, issuer, subject, id)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return m.raceWinner(tx, issuer, subject, err)
+		}
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// Called when InsertWithIdentity ran into a unique key. If that's because
+// another first login through the identity got there first, returns the ID of
+// the user it linked the identity to. Otherwise returns err
+func (m *UserModel) raceWinner(tx *sql.Tx, issuer, subject string, err error) (int, error) {
+	// The other login's rows are only visible once this transaction is over
+	tx.Rollback()
+
+	id, lookupErr := m.linkedUserID(issuer, subject)
+	if lookupErr != nil {
+		return 0, err
+	}
+	return id, nil
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestIsDuplicateKey(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "Duplicate key", err: &mysql.MySQLError{Number: 1062, Message: "Duplicate entry for key 'PRIMARY'"}, want: true},
+		{name: "Wrapped duplicate key", err: fmt.Errorf("link: %w", &mysql.MySQLError{Number: 1062}), want: true},
+		{name: "Foreign key", err: &mysql.MySQLError{Number: 1452}, want: false},
+		{name: "Other error", err: errors.New("connection refused"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := isDuplicateKey(tt.err)
+			if got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
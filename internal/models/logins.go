@@ -0,0 +1,182 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/dwang288/snippetbox/internal/ipaddr"
+)
+
+// Failed logins are counted separately for each email address and each IP
+// address they came from
+const (
+	LoginFailureEmail = "email"
+	LoginFailureIP    = "ip"
+)
+
+// LoginFailureModel keeps count of failed logins, so that password guessing
+// can be slowed down and stopped. Every email address gets counted, whether
+// or not it has an account, so the counts don't give away which ones do
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// Returns the name failures of this kind are stored under. Email addresses
+// are compared without case, and IPv6 addresses share a count with the rest
+// of their /64 network
+func loginFailureName(kind, value string) string {
+	value = strings.TrimSpace(value)
+	if kind == LoginFailureEmail {
+		return strings.ToLower(value)
+	}
+	return ipaddr.Network(value)
+}
+
+// LoginAttempt is a login attempt that has been counted as a failure before
+// its password was checked, see LoginFailureModel.Attempt
+type LoginAttempt struct {
+	Email string
+	IP    string
+	// How long logins stay blocked for if this attempt fails
+	Wait time.Duration
+
+	emailFailures int
+	ipFailures    int
+}
+
+// Attempt counts a login attempt as a failed one for the email address and the
+// IP address before the password is checked, so attempts made in parallel
+// can't all get in while the first ones are still being checked. The rows are
+// locked while they're read and counted, each attempt sees the ones before it.
+// delay says how long to block for after a number of failures.
+//
+// If the email or IP address is blocked, the attempt isn't counted, and nil and
+// how much longer it's blocked for are returned. Attempts that turn out to be
+// right are taken back with Forgive or Clear
+func (m *LoginFailureModel) Attempt(email, ip string, window time.Duration, delay func(kind string, failures int) time.Duration) (*LoginAttempt, time.Duration, error) {
+	attempt := &LoginAttempt{Email: email, IP: ip}
+	keys := []struct {
+		kind     string
+		name     string
+		failures *int
+	}{
+		// Always locked in this order, so two attempts can't deadlock
+		{LoginFailureEmail, loginFailureName(LoginFailureEmail, email), &attempt.emailFailures},
+		{LoginFailureIP, loginFailureName(LoginFailureIP, ip), &attempt.ipFailures},
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	var blocked time.Duration
+	for _, k := range keys {
+		// Makes sure there's a row to lock, and starts counts that have
+		// expired over
+		statement := `INSERT INTO login_failures (kind, name, failures, expires)
+		VALUES(?, ?, 0, UTC_TIMESTAMP(6))
+		ON DUPLICATE KEY UPDATE failures = IF(expires < UTC_TIMESTAMP(6), 0, failures)`
+		_, err = tx.Exec(statement, k.kind, k.name)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var microseconds int64
+		statement = `SELECT failures, GREATEST(COALESCE(TIMESTAMPDIFF(MICROSECOND, UTC_TIMESTAMP(6), blocked_until), 0), 0)
+		FROM login_failures
+		WHERE kind = ? AND name = ?
+		FOR UPDATE`
+		err = tx.QueryRow(statement, k.kind, k.name).Scan(k.failures, &microseconds)
+		if err != nil {
+			return nil, 0, err
+		}
+		if d := time.Duration(microseconds) * time.Microsecond; d > blocked {
+			blocked = d
+		}
+	}
+	// Rolling back drops the rows that were only made to be locked
+	if blocked > 0 {
+		return nil, blocked, nil
+	}
+
+	seconds := int(window.Seconds())
+	for _, k := range keys {
+		*k.failures++
+		d := delay(k.kind, *k.failures)
+		if d > attempt.Wait {
+			attempt.Wait = d
+		}
+
+		// A row is kept for as long as it's blocked too. MySQL applies the
+		// assignments in order, so expires sees the new blocked_until
+		statement := `UPDATE login_failures
+		SET failures = ?,
+			blocked_until = IF(? > 0, DATE_ADD(UTC_TIMESTAMP(6), INTERVAL ? MICROSECOND), blocked_until),
+			expires = GREATEST(DATE_ADD(UTC_TIMESTAMP(6), INTERVAL ? SECOND), COALESCE(blocked_until, UTC_TIMESTAMP(6)))
+		WHERE kind = ? AND name = ?`
+		_, err = tx.Exec(statement, *k.failures, d.Microseconds(), d.Microseconds(), seconds, k.kind, k.name)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, 0, err
+	}
+	return attempt, 0, nil
+}
+
+// Forgive takes back the failure an attempt that turned out right was counted
+// as, for the email address or the IP address. The block it started is lifted
+// too, unless other attempts have been counted since
+func (m *LoginFailureModel) Forgive(attempt *LoginAttempt, kind string) error {
+	value, failures := attempt.Email, attempt.emailFailures
+	if kind == LoginFailureIP {
+		value, failures = attempt.IP, attempt.ipFailures
+	}
+
+	// blocked_until is set first, while failures still has the old count
+	statement := `UPDATE login_failures
+	SET blocked_until = IF(failures = ?, NULL, blocked_until),
+		failures = GREATEST(failures - 1, 0)
+	WHERE kind = ? AND name = ?`
+	_, err := m.DB.Exec(statement, failures, kind, loginFailureName(kind, value))
+	return err
+}
+
+// Clear forgets the failures of the email address or IP address, which also
+// unblocks it. Returns false if there weren't any
+func (m *LoginFailureModel) Clear(kind, value string) (bool, error) {
+	statement := "DELETE FROM login_failures WHERE kind = ? AND name = ?"
+	result, err := m.DB.Exec(statement, kind, loginFailureName(kind, value))
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// DeleteExpired deletes up to limit counts that have started over and aren't
+// blocking anything, and returns how many were deleted
+func (m *LoginFailureModel) DeleteExpired(limit int) (int, error) {
+	statement := "DELETE FROM login_failures WHERE expires < UTC_TIMESTAMP(6) LIMIT ?"
+
+	result, err := m.DB.Exec(statement, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
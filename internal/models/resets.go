@@ -0,0 +1,117 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CreatePasswordReset returns a new token that lets the user choose a new
+// password without knowing their current one, until ttl has passed. Like API
+// tokens, only a hash of it is stored
+func (m *UserModel) CreatePasswordReset(id int, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	plaintext := base64.RawURLEncoding.EncodeToString(b)
+
+	stmt := `INSERT INTO password_resets (hash, user_id, expires)
+	VALUES(?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	_, err = m.DB.Exec(stmt, hashToken(plaintext), id, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+// CheckPasswordReset returns the ID of the user the reset token was made for.
+// Returns ErrInvalidCredentials if there's no such token, it has expired or
+// been used, or the user has been disabled since
+func (m *UserModel) CheckPasswordReset(plaintext string) (int, error) {
+	var id int
+
+	stmt := `SELECT r.user_id FROM password_resets r
+	INNER JOIN users u ON u.id = r.user_id
+	WHERE r.hash = ? AND r.expires > UTC_TIMESTAMP() AND NOT u.disabled`
+
+	err := m.DB.QueryRow(stmt, hashToken(plaintext)).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidCredentials
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// ResetPassword sets the password of the user the reset token was made for and
+// uses up every reset token the user has. Following the emailed link proves
+// the user owns the address, so it's marked as verified too. Returns the
+// user's ID, or ErrInvalidCredentials if the token can't be used
+func (m *UserModel) ResetPassword(plaintext, password string) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the token so two requests can't both use it
+	var id int
+	stmt := `SELECT r.user_id FROM password_resets r
+	INNER JOIN users u ON u.id = r.user_id
+	WHERE r.hash = ? AND r.expires > UTC_TIMESTAMP() AND NOT u.disabled
+	FOR UPDATE`
+	err = tx.QueryRow(stmt, hashToken(plaintext)).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidCredentials
+		}
+		return 0, err
+	}
+
+	stmt = "UPDATE users SET hashed_password = ?, verified = TRUE WHERE id = ?"
+	_, err = tx.Exec(stmt, string(hashedPassword), id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("DELETE FROM password_resets WHERE user_id = ?", id)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// DeleteExpiredPasswordResets deletes up to limit expired reset tokens and
+// returns how many were deleted
+func (m *UserModel) DeleteExpiredPasswordResets(limit int) (int, error) {
+	stmt := `DELETE FROM password_resets WHERE expires <= UTC_TIMESTAMP() LIMIT ?`
+
+	result, err := m.DB.Exec(stmt, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Revision is an immutable copy of a snippet's title and content, saved every
+// time the snippet is created or edited. Versions are numbered from 1
+type Revision struct {
+	ID        int
+	SnippetID int
+	Version   int
+	Title     string
+	Content   string
+	Created   time.Time
+	// ID and name of the user who saved this version
+	UserID   int
+	UserName string
+}
+
+// Columns selected by every revision query, in the order scanRevision expects them
+const revisionColumns = `r.id, r.snippet_id, r.version, r.title, r.content, r.created,
+	COALESCE(r.user_id, 0), COALESCE(u.name, '')`
+
+// Copies the revisionColumns of a single row into a new Revision struct
+func scanRevision(row scanner) (*Revision, error) {
+	r := &Revision{}
+	err := row.Scan(&r.ID, &r.SnippetID, &r.Version, &r.Title, &r.Content, &r.Created, &r.UserID, &r.UserName)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Snippets created before revisions were kept don't have a first version yet.
+// This records what such a snippet looked like when it was created, so it has
+// to run before the snippet is changed, in the same transaction
+func backfillRevision(tx *sql.Tx, snippetID int) error {
+	stmt := `INSERT INTO snippet_revisions (snippet_id, version, user_id, title, content, created)
+	SELECT id, 1, user_id, title, content, created FROM snippets
+	WHERE id = ? AND NOT EXISTS (SELECT true FROM snippet_revisions WHERE snippet_id = ?)`
+	_, err := tx.Exec(stmt, snippetID, snippetID)
+	return err
+}
+
+// Saves the snippet's current title and content as its next version. Must run in
+// the same transaction as the write that changed the snippet
+func insertRevision(tx *sql.Tx, snippetID, userID int) error {
+	// Nobody else can add a version until the transaction commits, because the
+	// write to the snippet row holds a lock on it
+	stmt := `INSERT INTO snippet_revisions (snippet_id, version, user_id, title, content, created)
+	SELECT s.id, (SELECT COALESCE(MAX(version), 0) + 1 FROM snippet_revisions WHERE snippet_id = s.id),
+	NULLIF(?, 0), s.title, s.content, UTC_TIMESTAMP()
+	FROM snippets s WHERE s.id = ?`
+	_, err := tx.Exec(stmt, userID, snippetID)
+	return err
+}
+
+// Returns every version of a snippet, newest first
+func (m *SnippetModel) Revisions(snippetID int) ([]*Revision, error) {
+	stmt := `SELECT ` + revisionColumns + `
+	FROM snippet_revisions r LEFT JOIN users u ON u.id = r.user_id
+	WHERE r.snippet_id = ? ORDER BY r.version DESC`
+
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*Revision{}
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// Returns a single version of a snippet
+func (m *SnippetModel) Revision(snippetID, version int) (*Revision, error) {
+	stmt := `SELECT ` + revisionColumns + `
+	FROM snippet_revisions r LEFT JOIN users u ON u.id = r.user_id
+	WHERE r.snippet_id = ? AND r.version = ?`
+
+	r, err := scanRevision(m.DB.QueryRow(stmt, snippetID, version))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return r, nil
+}
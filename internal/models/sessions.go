@@ -0,0 +1,35 @@
+package models
+
+import "database/sql"
+
+// SessionModel wraps the sessions table used by the scs mysqlstore, which the
+// store itself would otherwise only clean up in one unbounded query
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// DeleteExpired deletes up to limit expired sessions and returns how many were
+// deleted
+func (m *SessionModel) DeleteExpired(limit int) (int, error) {
+	stmt := `DELETE FROM sessions WHERE expiry < UTC_TIMESTAMP(6) LIMIT ?`
+
+	result, err := m.DB.Exec(stmt, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// Count returns the number of sessions that haven't expired
+func (m *SessionModel) Count() (int, error) {
+	var count int
+	stmt := `SELECT COUNT(*) FROM sessions WHERE expiry >= UTC_TIMESTAMP(6)`
+
+	err := m.DB.QueryRow(stmt).Scan(&count)
+	return count, err
+}
@@ -1,18 +1,165 @@
 package models
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// Individual snippet data struct, matches DB table
+// Individual snippet data struct, matches DB table. The JSON field names are
+// the ones used by the API
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	Content string    `json:"content"`
+	Created time.Time `json:"created"`
+	// Nil for snippets that never expire
+	Expires *time.Time `json:"expires"`
+	// ID and name of the user who created the snippet. Both are left zeroed
+	// for snippets created before snippets were linked to their authors
+	UserID   int      `json:"userId,omitempty"`
+	UserName string   `json:"userName,omitempty"`
+	Tags     []string `json:"tags"`
+	// Name of the language the content is highlighted as, empty for plain text
+	Language string `json:"language"`
+	// How the content is displayed, one of the Format constants
+	Format string `json:"format"`
+	// Who can see the snippet, one of the Visibility constants
+	Visibility string `json:"visibility"`
+	// Random identifier used in the URLs of unlisted and private snippets
+	Slug string `json:"slug,omitempty"`
+	// Number of times the snippet may be viewed by someone other than its
+	// author before it's deleted, 0 for no limit. Views counts those views
+	MaxViews int `json:"maxViews,omitempty"`
+	Views    int `json:"views,omitempty"`
+	// ID of the snippet this one was forked from, 0 if it isn't a fork or the
+	// original has since been deleted
+	ForkedFrom int `json:"forkedFrom,omitempty"`
+	// Files bundled with the snippet besides its own content. Only loaded by
+	// Get and GetBySlug
+	Files []*SnippetFile `json:"files,omitempty"`
+}
+
+// Ref returns the identifier used for the snippet in URLs. Public snippets are
+// found by their ID, everything else only by its unguessable slug
+func (s *Snippet) Ref() string {
+	if s.Visibility == VisibilityPublic || s.Slug == "" {
+		return strconv.Itoa(s.ID)
+	}
+	return s.Slug
+}
+
+// Who can see a snippet
+const (
+	VisibilityPublic   = "public"   // Anyone, and listed everywhere
+	VisibilityUnlisted = "unlisted" // Anyone with the link, but never listed
+	VisibilityPrivate  = "private"  // Only the author
+)
+
+// Returns a random, URL safe slug with 128 bits of entropy
+func newSlug() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Ways a snippet's content can be displayed
+const (
+	FormatCode     = "code"     // Syntax highlighted source
+	FormatMarkdown = "markdown" // Rendered as HTML
+)
+
+// Orders that snippet listings can be sorted in
+const (
+	SortCreated = "created" // Newest first
+	SortExpires = "expires" // Expiring soonest first
+)
+
+// Snippets that never expire are sorted as if they expired at the end of time
+const endOfTime = "9999-12-31 23:59:59"
+
+// Column each sort order is keyed on, along with the snippet ID as a tie breaker
+var snippetSorts = map[string]struct {
+	column string
+	desc   bool
+}{
+	SortCreated: {column: "s.created", desc: true},
+	SortExpires: {column: "COALESCE(s.expires, '" + endOfTime + "')", desc: false},
+}
+
+// Cursor marks the edge of a page in a listing, by the sort key and ID of the
+// snippet there. It carries the key itself, so it keeps working once that
+// snippet has expired or been deleted, and reading it never looks at a
+// snippet the user may not be allowed to see
+type Cursor struct {
+	Key time.Time
+	ID  int
+}
+
+// CursorFor returns the cursor of a snippet in a listing in the sort order
+func CursorFor(sort string, s *Snippet) Cursor {
+	if sort != SortExpires {
+		return Cursor{Key: s.Created, ID: s.ID}
+	}
+	if s.Expires == nil {
+		key, _ := time.Parse(time.DateTime, endOfTime)
+		return Cursor{Key: key, ID: s.ID}
+	}
+	return Cursor{Key: *s.Expires, ID: s.ID}
+}
+
+// String encodes the cursor for URLs, as <unix seconds>_<id>
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d_%d", c.Key.Unix(), c.ID)
+}
+
+// ParseCursor reverses Cursor.String
+func ParseCursor(s string) (Cursor, error) {
+	key, id, ok := strings.Cut(s, "_")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	seconds, err := strconv.ParseInt(key, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	c := Cursor{Key: time.Unix(seconds, 0).UTC()}
+	c.ID, err = strconv.Atoi(id)
+	if err != nil || c.ID < 1 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Condition shared by every query that should skip expired snippets. A NULL
+// expiry means the snippet never expires
+const notExpired = `(s.expires IS NULL OR s.expires > UTC_TIMESTAMP())`
+
+// ListOptions selects a page of snippets. Pages are found by keyset pagination,
+// with the last (or first) snippet on the current page as the cursor
+type ListOptions struct {
+	Sort   string  // One of the Sort constants, defaults to SortCreated
+	After  *Cursor // Return the page that follows this cursor
+	Before *Cursor // Return the page that precedes this cursor
+	Limit  int     // Maximum number of snippets on a page
+	Tag    string  // Only list snippets with this tag, if set
+	// Only list this user's snippets, including unlisted and private ones, if set
+	UserID int
+}
+
+// A page of snippets, along with whether there are pages on either side of it
+type SnippetPage struct {
+	Snippets []*Snippet
+	HasNext  bool
+	HasPrev  bool
 }
 
 // Wrapper type for the db connection pool
@@ -20,15 +167,62 @@ type SnippetModel struct {
 	DB *sql.DB
 }
 
-// Insert new snippet into DB and return its ID in the db
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+// Columns selected by every snippet query, in the order scanSnippet expects them.
+// The users table is LEFT JOINed so that snippets without an author still come back
+const snippetColumns = `s.id, s.title, s.content, s.created, s.expires,
+	COALESCE(s.user_id, 0), COALESCE(u.name, ''), s.language, s.format,
+	s.visibility, COALESCE(s.slug, ''), COALESCE(s.max_views, 0), s.views,
+	COALESCE(s.forked_from, 0)`
+
+// Tables to select snippetColumns from
+const snippetTables = `snippets s LEFT JOIN users u ON u.id = s.user_id`
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// Copies the snippetColumns of a single row into a new Snippet struct
+func scanSnippet(row scanner) (*Snippet, error) {
+	s := &Snippet{}
+	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.UserName,
+		&s.Language, &s.Format, &s.Visibility, &s.Slug, &s.MaxViews, &s.Views, &s.ForkedFrom)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Insert saves a new snippet to the DB and returns its ID. The snippet's author
+// is taken from s.UserID, 0 for anonymous snippets, and it expires at s.Expires,
+// or never if that's nil. s.ForkedFrom is recorded as the snippet it was forked
+// from. The snippet's tags, extra files and first revision are saved along with
+// it. s.ID and s.Slug are filled in with the values the snippet was saved with
+func (m *SnippetModel) Insert(s *Snippet) (int, error) {
+	// Every snippet gets a slug, so its visibility can be changed later on
+	slug, err := newSlug()
+	if err != nil {
+		return 0, err
+	}
+
+	// Use a transaction so that a snippet is never saved without its tags and
+	// first revision
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
 	// Insert SQL statement, use ? as placeholder to prevent SQL injections instead of
 	// interpolating values into the string
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+	stmt := `INSERT INTO snippets (user_id, title, content, language, format, visibility, slug,
+	max_views, forked_from, created, expires)
+	VALUES(NULLIF(?, 0), ?, ?, ?, ?, ?, ?, NULLIF(?, 0), NULLIF(?, 0), UTC_TIMESTAMP(), ?)`
 
 	// Execute the statement along with variables for placeholders
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	result, err := tx.Exec(stmt, s.UserID, s.Title, s.Content, s.Language, s.Format, s.Visibility, slug,
+		s.MaxViews, s.ForkedFrom, s.Expires)
 	if err != nil {
 		return 0, err
 	}
@@ -39,27 +233,325 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (int, e
 		return 0, err
 	}
 
+	err = setTags(tx, int(id), s.Tags)
+	if err != nil {
+		return 0, err
+	}
+
+	err = setFiles(tx, int(id), s.Files)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertRevision(tx, int(id), s.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
 	// Convert returned result from int64 to int
-	return int(id), nil
+	s.ID = int(id)
+	s.Slug = slug
+	return s.ID, nil
+}
+
+// Update replaces the title, content, language, format, visibility, view limit,
+// expiry, tags and extra files of the snippet with ID s.ID. Views already counted are kept.
+// If the title or content changed, the new version is saved as a revision
+// authored by userID
+func (m *SnippetModel) Update(s *Snippet, userID int) error {
+	// Snippets created before slugs existed get one the first time they're saved
+	slug, err := newSlug()
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so concurrent edits are saved as separate revisions one after
+	// the other
+	var currentTitle, currentContent string
+	stmt := `SELECT title, content FROM snippets WHERE id = ? FOR UPDATE`
+	err = tx.QueryRow(stmt, s.ID).Scan(&currentTitle, &currentContent)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	// Revisions only keep the title and content, so changing anything else
+	// doesn't create a new version
+	changed := s.Title != currentTitle || s.Content != currentContent
+	if changed {
+		err = backfillRevision(tx, s.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt = `UPDATE snippets SET title = ?, content = ?, language = ?, format = ?,
+	visibility = ?, slug = COALESCE(slug, ?), max_views = NULLIF(?, 0), expires = ?
+	WHERE id = ?`
+	_, err = tx.Exec(stmt, s.Title, s.Content, s.Language, s.Format, s.Visibility, slug, s.MaxViews,
+		s.Expires, s.ID)
+	if err != nil {
+		return err
+	}
+
+	err = setTags(tx, s.ID, s.Tags)
+	if err != nil {
+		return err
+	}
+
+	err = setFiles(tx, s.ID, s.Files)
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		err = insertRevision(tx, s.ID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a snippet along with its revisions, tags and files from the DB
+func (m *SnippetModel) Delete(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteSnippet(tx, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Deletes a snippet and the rows that reference it inside a transaction.
+// Returns ErrNoRecord if the snippet doesn't exist
+func deleteSnippet(tx *sql.Tx, id int) error {
+	deleted, err := deleteSnippets(tx, []int{id})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Deletes a batch of snippets and the rows that reference them inside a
+// transaction. Returns the number of snippets that were deleted
+func deleteSnippets(tx *sql.Tx, ids []int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// Build the IN (?, ?, ...) placeholders for the IDs
+	in := "(?" + strings.Repeat(", ?", len(ids)-1) + ")"
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	// Remove the rows that reference the snippets first. Forks are kept, they
+	// just no longer point back at a deleted original
+	for _, stmt := range []string{
+		`DELETE FROM snippet_revisions WHERE snippet_id IN ` + in,
+		`DELETE FROM snippet_tags WHERE snippet_id IN ` + in,
+		`DELETE FROM snippet_files WHERE snippet_id IN ` + in,
+		`UPDATE snippets SET forked_from = NULL WHERE forked_from IN ` + in,
+	} {
+		_, err := tx.Exec(stmt, args...)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM snippets WHERE id IN `+in, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// DeleteExpired deletes up to limit expired snippets, oldest expiry first, and
+// returns how many were deleted
+func (m *SnippetModel) DeleteExpired(limit int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the rows so a snippet can't be edited to expire later while it's
+	// being deleted
+	stmt := `SELECT id FROM snippets
+	WHERE expires IS NOT NULL AND expires <= UTC_TIMESTAMP()
+	ORDER BY expires LIMIT ? FOR UPDATE`
+	rows, err := tx.Query(stmt, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	deleted, err := deleteSnippets(tx, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// Numbers of snippets, for the admin tool
+type SnippetStats struct {
+	Total    int
+	Public   int
+	Unlisted int
+	Private  int
+	// Snippets that have expired but haven't been deleted yet
+	Expired int
+}
+
+// Stats counts the snippets, including expired ones
+func (m *SnippetModel) Stats() (*SnippetStats, error) {
+	stats := &SnippetStats{}
+
+	stmt := `SELECT COUNT(*),
+	COALESCE(SUM(visibility = ?), 0),
+	COALESCE(SUM(visibility = ?), 0),
+	COALESCE(SUM(visibility = ?), 0),
+	COALESCE(SUM(expires IS NOT NULL AND expires <= UTC_TIMESTAMP()), 0)
+	FROM snippets`
+
+	err := m.DB.QueryRow(stmt, VisibilityPublic, VisibilityUnlisted, VisibilityPrivate).
+		Scan(&stats.Total, &stats.Public, &stats.Unlisted, &stats.Private, &stats.Expired)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// RecordView counts a view of a snippet that has a view limit and returns how
+// many views it has left. The snippet is deleted once it has no views left, so
+// a snippet limited to a single view is burned after the first read. Returns
+// ErrNoRecord if the snippet is gone, e.g. because another reader used up its
+// last view first
+func (m *SnippetModel) RecordView(id int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so concurrent readers are counted one at a time. Whoever comes
+	// after the last view finds the row deleted
+	var views, maxViews int
+	stmt := `SELECT s.views, s.max_views FROM snippets s
+	WHERE s.id = ? AND ` + notExpired + ` AND s.max_views IS NOT NULL FOR UPDATE`
+	err = tx.QueryRow(stmt, id).Scan(&views, &maxViews)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	remaining := maxViews - views - 1
+	if remaining <= 0 {
+		remaining = 0
+		err = deleteSnippet(tx, id)
+	} else {
+		_, err = tx.Exec(`UPDATE snippets SET views = views + 1 WHERE id = ?`, id)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return remaining, nil
 }
 
-// Returns snippet based on ID
+// Returns snippet based on ID, whatever its visibility. Callers have to check
+// that the snippet may be shown to the user
 func (m *SnippetModel) Get(id int) (*Snippet, error) {
+	return m.get("s.id = ?", id)
+}
+
+// Returns snippet based on its slug, whatever its visibility. Callers have to
+// check that the snippet may be shown to the user
+func (m *SnippetModel) GetBySlug(slug string) (*Snippet, error) {
+	return m.get("s.slug = ?", slug)
+}
+
+// IDBySlug returns the ID of the snippet with this slug, even if it has
+// expired. For the admin tool, which has to be able to delete any snippet
+func (m *SnippetModel) IDBySlug(slug string) (int, error) {
+	var id int
+	err := m.DB.QueryRow("SELECT id FROM snippets WHERE slug = ?", slug).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// Returns the unexpired snippet matching the where clause
+func (m *SnippetModel) get(where string, arg any) (*Snippet, error) {
 
 	// Select statement meant to be sent to DB as a prepared statement
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() AND id = ?`
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+	WHERE ` + notExpired + ` AND ` + where
 
-	// Query through the db connection pool with the statement and the id for the
+	// Query through the db connection pool with the statement and the arg for the
 	// placeholder param. Returns a pointer to a sql.Row object with the db result
-	row := m.DB.QueryRow(stmt, id)
-
-	// Initialize a pointer to a new zeroed Snippet struct
-	s := &Snippet{}
+	row := m.DB.QueryRow(stmt, arg)
 
-	// Copies values from each column in the row into the struct's values.
-	// Must have same number of params as columns.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	// Copies values from each column in the row into a new Snippet struct.
+	s, err := scanSnippet(row)
 	if err != nil {
 		// If the error is a sql.ErrNoRows error (a known exception for a known valid
 		// case) then return our custom error type.
@@ -70,16 +562,164 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 		}
 	}
 
+	err = m.attachTags([]*Snippet{s})
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.attachFiles(s)
+	if err != nil {
+		return nil, err
+	}
+
 	return s, nil
 }
 
-// Returns most recently created snippets
+// Returns most recently created public snippets
 func (m *SnippetModel) Latest() ([]*Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10`
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+	WHERE ` + notExpired + ` AND s.visibility = 'public'
+	ORDER BY s.id DESC LIMIT 10`
+
+	return m.query(stmt)
+}
+
+// Returns a page of unexpired public snippets, or of all of opts.UserID's
+// snippets, in the order given by opts.Sort
+func (m *SnippetModel) List(opts ListOptions) (*SnippetPage, error) {
+	if opts.Limit < 1 {
+		opts.Limit = 10
+	}
+
+	stmt, args := listQuery(opts)
+	snippets, err := m.query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &SnippetPage{}
+	more := len(snippets) > opts.Limit
+	if more {
+		snippets = snippets[:opts.Limit]
+	}
+	if opts.Before != nil {
+		for i, j := 0, len(snippets)-1; i < j; i, j = i+1, j-1 {
+			snippets[i], snippets[j] = snippets[j], snippets[i]
+		}
+		page.HasPrev = more
+		page.HasNext = true
+	} else {
+		page.HasNext = more
+		page.HasPrev = opts.After != nil
+	}
+	page.Snippets = snippets
+
+	return page, nil
+}
+
+// Builds the query for List. Asks for one row more than opts.Limit, to find
+// out if there's another page after this one
+func listQuery(opts ListOptions) (string, []any) {
+	sort, ok := snippetSorts[opts.Sort]
+	if !ok {
+		sort = snippetSorts[SortCreated]
+	}
 
+	// Walking backwards from a cursor flips both the comparison and the order,
+	// List puts the rows back in the right order afterwards
+	cursor := opts.After
+	if opts.Before != nil {
+		cursor = opts.Before
+	}
+	cmp, dir := ">", "ASC"
+	if sort.desc != (opts.Before != nil) {
+		cmp, dir = "<", "DESC"
+	}
+
+	where := notExpired + " AND s.visibility = 'public'"
+	args := []any{}
+	if opts.UserID != 0 {
+		where = notExpired + " AND s.user_id = ?"
+		args = append(args, opts.UserID)
+	}
+	if opts.Tag != "" {
+		where += ` AND s.id IN (SELECT st.snippet_id FROM snippet_tags st
+		JOIN tags t ON t.id = st.tag_id WHERE t.name = ?)`
+		args = append(args, opts.Tag)
+	}
+	if cursor != nil {
+		// Compare against the cursor's sort key so that snippets sharing the
+		// same value are still paged through in ID order
+		where += fmt.Sprintf(" AND (%s, s.id) %s (?, ?)", sort.column, cmp)
+		args = append(args, cursor.Key.UTC().Format(time.DateTime), cursor.ID)
+	}
+
+	stmt := fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY %s %s, s.id %s LIMIT ?`,
+		snippetColumns, snippetTables, where, sort.column, dir, dir)
+	args = append(args, opts.Limit+1)
+
+	return stmt, args
+}
+
+// Number of snippets on each page of search results
+const SearchPageSize = 20
+
+// Returns a page of unexpired public snippets whose title or content match the query,
+// best matches first. Pages are numbered from 1 and hold SearchPageSize snippets.
+// Relies on a FULLTEXT index over (title, content)
+func (m *SnippetModel) Search(query string, page int) (*SnippetPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	limit := SearchPageSize
+
+	// Relevance can't be used as a keyset cursor, so search results are paged
+	// by offset instead. Ask for one extra row to find out if there's a next page
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+	WHERE ` + notExpired + ` AND s.visibility = 'public'
+	AND MATCH(s.title, s.content) AGAINST(? IN NATURAL LANGUAGE MODE)
+	ORDER BY MATCH(s.title, s.content) AGAINST(? IN NATURAL LANGUAGE MODE) DESC, s.id DESC
+	LIMIT ? OFFSET ?`
+
+	snippets, err := m.query(stmt, query, query, limit+1, (page-1)*limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SnippetPage{HasPrev: page > 1}
+	if len(snippets) > limit {
+		snippets = snippets[:limit]
+		result.HasNext = true
+	}
+	result.Snippets = snippets
+
+	return result, nil
+}
+
+// Returns the unexpired snippets created by the user, newest first. Includes
+// unlisted and private snippets, so only show these to the user themselves
+func (m *SnippetModel) ByUser(userID int) ([]*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+	WHERE ` + notExpired + ` AND s.user_id = ? ORDER BY s.id DESC`
+
+	return m.query(stmt, userID)
+}
+
+// Returns the unexpired forks of a snippet, newest first. Only public forks are
+// included, along with any fork created by userID
+func (m *SnippetModel) Forks(id, userID int) ([]*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM ` + snippetTables + `
+	WHERE ` + notExpired + ` AND s.forked_from = ?
+	AND (s.visibility = 'public' OR s.user_id = ?) ORDER BY s.id DESC`
+
+	return m.query(stmt, id, userID)
+}
+
+// Runs a query selecting snippetColumns and collects every row into a slice,
+// along with the tags of each snippet
+func (m *SnippetModel) query(stmt string, args ...any) ([]*Snippet, error) {
 	// DB.Query() returns multiple rows
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -92,10 +732,8 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	// Iterate through result set. Upon completion then resultset automatically
 	// closes and frees up the underlying database connection
 	for rows.Next() {
-		// Create a pointer to a new zeroed Snippet struct
-		s := &Snippet{}
 		// Scan like you would a single row query
-		rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		s, err := scanSnippet(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -106,5 +744,11 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+
+	// Every listing shows the tags of its snippets
+	err = m.attachTags(snippets)
+	if err != nil {
+		return nil, err
+	}
 	return snippets, nil
 }
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	expires := time.Date(2024, 3, 2, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		sort    string
+		snippet *Snippet
+		want    string
+	}{
+		{
+			name:    "Newest first",
+			sort:    SortCreated,
+			snippet: &Snippet{ID: 7, Created: created, Expires: &expires},
+			want:    "1709296200_7",
+		},
+		{
+			name:    "Expiring soonest first",
+			sort:    SortExpires,
+			snippet: &Snippet{ID: 7, Created: created, Expires: &expires},
+			want:    "1709382600_7",
+		},
+		{
+			name:    "Never expires",
+			sort:    SortExpires,
+			snippet: &Snippet{ID: 7, Created: created},
+			want:    "253402300799_7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := CursorFor(tt.sort, tt.snippet)
+			got := cursor.String()
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+
+			parsed, err := ParseCursor(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !parsed.Key.Equal(cursor.Key) || parsed.ID != cursor.ID {
+				t.Errorf("got %+v back, want %+v", parsed, cursor)
+			}
+		})
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	for _, s := range []string{"", "7", "_7", "1709296200_", "1709296200_0", "1709296200_-1", "x_7", "1709296200_x"} {
+		_, err := ParseCursor(s)
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("ParseCursor(%q): got error %v, want %v", s, err, ErrInvalidCursor)
+		}
+	}
+}
+
+// A cursor that points at a snippet which has expired or been deleted still
+// continues the listing from its own key and ID, since the query compares
+// against those without reading the snippet
+func TestListQueryCursorOfDeletedSnippet(t *testing.T) {
+	key := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		opts     ListOptions
+		wantCmp  string
+		wantDir  string
+		wantArgs []any
+	}{
+		{
+			name:     "After, newest first",
+			opts:     ListOptions{Sort: SortCreated, After: &Cursor{Key: key, ID: 7}, Limit: 20},
+			wantCmp:  "(s.created, s.id) < (?, ?)",
+			wantDir:  "ORDER BY s.created DESC, s.id DESC",
+			wantArgs: []any{"2024-03-01 12:30:00", 7, 21},
+		},
+		{
+			name:     "Before, newest first",
+			opts:     ListOptions{Sort: SortCreated, Before: &Cursor{Key: key, ID: 7}, Limit: 20},
+			wantCmp:  "(s.created, s.id) > (?, ?)",
+			wantDir:  "ORDER BY s.created ASC, s.id ASC",
+			wantArgs: []any{"2024-03-01 12:30:00", 7, 21},
+		},
+		{
+			name:     "After, expiring soonest first",
+			opts:     ListOptions{Sort: SortExpires, After: &Cursor{Key: key, ID: 7}, Limit: 20},
+			wantCmp:  "(COALESCE(s.expires, '9999-12-31 23:59:59'), s.id) > (?, ?)",
+			wantDir:  "ORDER BY COALESCE(s.expires, '9999-12-31 23:59:59') ASC, s.id ASC",
+			wantArgs: []any{"2024-03-01 12:30:00", 7, 21},
+		},
+		{
+			name:     "Own snippets",
+			opts:     ListOptions{After: &Cursor{Key: key, ID: 7}, Limit: 20, UserID: 3},
+			wantCmp:  "(s.created, s.id) < (?, ?)",
+			wantDir:  "ORDER BY s.created DESC, s.id DESC",
+			wantArgs: []any{3, "2024-03-01 12:30:00", 7, 21},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, args := listQuery(tt.opts)
+
+			if !strings.Contains(stmt, tt.wantCmp) {
+				t.Errorf("query doesn't compare %s:\n%s", tt.wantCmp, stmt)
+			}
+			if !strings.Contains(stmt, tt.wantDir) {
+				t.Errorf("query doesn't %s:\n%s", tt.wantDir, stmt)
+			}
+			if strings.Contains(stmt, "FROM snippets s WHERE s.id") {
+				t.Errorf("query looks up the cursor's snippet:\n%s", stmt)
+			}
+			if len(args) != len(tt.wantArgs) {
+				t.Fatalf("got arguments %v, want %v", args, tt.wantArgs)
+			}
+			for i := range args {
+				if args[i] != tt.wantArgs[i] {
+					t.Errorf("got arguments %v, want %v", args, tt.wantArgs)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Replaces the tags of a snippet. Tags that don't exist yet are created. Must run
+// in the same transaction as the write that saves the snippet
+func setTags(tx *sql.Tx, snippetID int, tags []string) error {
+	_, err := tx.Exec(`DELETE FROM snippet_tags WHERE snippet_id = ?`, snippetID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		// Tag names are unique, so IGNORE skips the ones that already exist
+		_, err = tx.Exec(`INSERT IGNORE INTO tags (name) VALUES (?)`, tag)
+		if err != nil {
+			return err
+		}
+
+		stmt := `INSERT INTO snippet_tags (snippet_id, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?`
+		_, err = tx.Exec(stmt, snippetID, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Loads the tags of every snippet in the slice with a single query
+func (m *SnippetModel) attachTags(snippets []*Snippet) error {
+	if len(snippets) == 0 {
+		return nil
+	}
+
+	// Look snippets up by ID while building the IN (?, ?, ...) placeholders
+	byID := make(map[int]*Snippet, len(snippets))
+	args := make([]any, len(snippets))
+	for i, s := range snippets {
+		byID[s.ID] = s
+		args[i] = s.ID
+	}
+
+	stmt := `SELECT st.snippet_id, t.name
+	FROM snippet_tags st JOIN tags t ON t.id = st.tag_id
+	WHERE st.snippet_id IN (?` + strings.Repeat(", ?", len(snippets)-1) + `)
+	ORDER BY t.name`
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var tag string
+		err = rows.Scan(&id, &tag)
+		if err != nil {
+			return err
+		}
+		byID[id].Tags = append(byID[id].Tags, tag)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,170 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Scopes an API token can be given
+const (
+	ScopeRead  = "read"  // Read the user's snippets, including unlisted and private ones
+	ScopeWrite = "write" // Create, change and delete the user's snippets
+)
+
+// Prefix of every API token, so that leaked tokens are easy to recognise
+const tokenPrefix = "snip_"
+
+// A personal API token that lets programs act as the user who created it. The
+// token itself is only known when it's created, just its hash is stored
+type Token struct {
+	ID     int
+	UserID int
+	// Name given by the user to remember what the token is used for
+	Name   string
+	Scopes []string
+	// Nil for tokens that never expire
+	Expires  *time.Time
+	Created  time.Time
+	LastUsed *time.Time
+}
+
+// HasScope returns true if the token was given the scope
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrapper type for the db connection pool
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// Tokens are 256 random bits, so a plain SHA-256 is enough to keep them safe
+// at rest. A slow hash like bcrypt would only slow down every API request
+func hashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// Insert creates a new token for the user and returns it. This is the only time
+// the token can be read, so it has to be shown to the user straight away
+func (m *TokenModel) Insert(userID int, name string, scopes []string, expires *time.Time) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	plaintext := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	stmt := `INSERT INTO tokens (user_id, name, hash, scopes, expires, created)
+	VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err = m.DB.Exec(stmt, userID, name, hashToken(plaintext), strings.Join(scopes, ","), expires)
+	if err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+// Authenticate returns the unexpired token matching the plaintext token and
+// records that it was used. Returns ErrInvalidCredentials if there's no such
+// token, or its user has been disabled
+func (m *TokenModel) Authenticate(plaintext string) (*Token, error) {
+	stmt := `SELECT t.id, t.user_id, t.name, t.scopes, t.expires, t.created, t.last_used
+	FROM tokens t INNER JOIN users u ON u.id = t.user_id
+	WHERE t.hash = ? AND (t.expires IS NULL OR t.expires > UTC_TIMESTAMP()) AND NOT u.disabled`
+
+	t, err := scanToken(m.DB.QueryRow(stmt, hashToken(plaintext)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	_, err = m.DB.Exec(`UPDATE tokens SET last_used = UTC_TIMESTAMP() WHERE id = ?`, t.ID)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Returns the user's tokens, including expired ones, newest first
+func (m *TokenModel) ByUser(userID int) ([]*Token, error) {
+	stmt := `SELECT id, user_id, name, scopes, expires, created, last_used FROM tokens
+	WHERE user_id = ? ORDER BY id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Delete revokes one of the user's tokens. Returns ErrNoRecord if the user
+// doesn't have a token with this ID
+func (m *TokenModel) Delete(id, userID int) error {
+	result, err := m.DB.Exec(`DELETE FROM tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// DeleteByUser revokes all of the user's tokens and returns how many there were
+func (m *TokenModel) DeleteByUser(userID int) (int, error) {
+	result, err := m.DB.Exec(`DELETE FROM tokens WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// Copies a row of token columns into a new Token struct
+func scanToken(row scanner) (*Token, error) {
+	t := &Token{}
+	var scopes string
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Expires, &t.Created, &t.LastUsed)
+	if err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	return t, nil
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+)
+
+// Number of recovery codes a user gets when turning on two-factor authentication
+const RecoveryCodeCount = 10
+
+// Recovery codes are 80 random bits, written as four groups of four base32
+// characters so they're easy to copy down
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Returns count new recovery codes
+func newRecoveryCodes(count int) ([]string, error) {
+	codes := make([]string, count)
+	for i := range codes {
+		b := make([]byte, 10)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16]
+	}
+	return codes, nil
+}
+
+// Codes are compared without their dashes and case, however they were typed in
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// TOTPSecret returns the secret of the user's authenticator app, or nil if the
+// user hasn't turned on two-factor authentication
+func (m *UserModel) TOTPSecret(id int) ([]byte, error) {
+	var secret []byte
+
+	statement := "SELECT totp_secret FROM users WHERE id = ?"
+	err := m.DB.QueryRow(statement, id).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EnableTwoFactor turns on two-factor authentication with the secret and
+// returns a fresh set of recovery codes. step is the time step of the code the
+// user confirmed the secret with, which can't be used again. Like reset
+// tokens, only hashes of the recovery codes are stored
+func (m *UserModel) EnableTwoFactor(id int, secret []byte, step int64) ([]string, error) {
+	codes, err := newRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	statement := "UPDATE users SET totp_secret = ?, totp_last_step = ? WHERE id = ?"
+	_, err = tx.Exec(statement, secret, step, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		statement = "INSERT INTO recovery_codes (user_id, hash) VALUES(?, ?)"
+		_, err = tx.Exec(statement, id, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor turns off two-factor authentication and deletes the user's
+// recovery codes
+func (m *UserModel) DisableTwoFactor(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statement := "UPDATE users SET totp_secret = NULL, totp_last_step = 0 WHERE id = ?"
+	_, err = tx.Exec(statement, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the user logged in with the code of this time step.
+// Returns false if a code from this step or a later one has already been used,
+// so that a code seen over someone's shoulder can't be used again
+func (m *UserModel) UseTOTPStep(id int, step int64) (bool, error) {
+	statement := "UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?"
+	result, err := m.DB.Exec(statement, step, id, step)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// UseRecoveryCode deletes the recovery code if the user has it. Returns false
+// if the user has no such code
+func (m *UserModel) UseRecoveryCode(id int, code string) (bool, error) {
+	statement := "DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?"
+	result, err := m.DB.Exec(statement, id, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// RecoveryCodesLeft returns the number of recovery codes the user hasn't used
+func (m *UserModel) RecoveryCodesLeft(id int) (int, error) {
+	var count int
+	statement := "SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?"
+	err := m.DB.QueryRow(statement, id).Scan(&count)
+	return count, err
+}
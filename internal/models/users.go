@@ -18,6 +18,45 @@ type User struct {
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	// Disabled users can't log in or use their API tokens
+	Disabled bool
+	// Users can't log in until they've followed the link emailed to them
+	Verified bool
+	// Set for users who also need a code from an authenticator app to log in
+	TwoFactor bool
+	// Goes up every time the user is logged out everywhere. Sessions remember
+	// the generation they were logged in under and stop counting once it's
+	// gone up
+	SessionGeneration int
+}
+
+// UserModelInterface has the UserModel methods the web server uses, so its
+// handlers can be tested against users kept in memory
+type UserModelInterface interface {
+	Get(id int) (*User, error)
+	GetByEmail(email string) (*User, error)
+	Insert(name, email, password string) (int, error)
+	Authenticate(email, password string) (int, error)
+	LoggedIn(id, generation int) (bool, error)
+	LogOutEverywhere(id int) error
+	CheckPassword(id int, password string) error
+	UpdateHashedPassword(id int, password string) error
+	Verify(id int, email string) error
+
+	CreatePasswordReset(id int, ttl time.Duration) (string, error)
+	CheckPasswordReset(plaintext string) (int, error)
+	ResetPassword(plaintext, password string) (int, error)
+
+	TOTPSecret(id int) ([]byte, error)
+	EnableTwoFactor(id int, secret []byte, step int64) ([]string, error)
+	DisableTwoFactor(id int) error
+	UseTOTPStep(id int, step int64) (bool, error)
+	UseRecoveryCode(id int, code string) (bool, error)
+	RecoveryCodesLeft(id int) (int, error)
+
+	GetByIdentity(issuer, subject string) (*User, error)
+	LinkIdentity(id int, issuer, subject string) error
+	InsertWithIdentity(name, email, issuer, subject string) (int, error)
 }
 
 // UserModel type that wraps a DB connection pool.
@@ -26,13 +65,23 @@ type UserModel struct {
 }
 
 func (m *UserModel) Get(id int) (*User, error) {
+	return m.get("id = ?", id)
+}
+
+// GetByEmail returns the user with this email address
+func (m *UserModel) GetByEmail(email string) (*User, error) {
+	return m.get("email = ?", email)
+}
+
+// Returns the user matching the WHERE condition, ErrNoRecord if there's none
+func (m *UserModel) get(where string, args ...any) (*User, error) {
 	u := &User{}
 
-	statement := `SELECT id, name, email, created
+	statement := `SELECT id, name, email, created, disabled, verified, totp_secret IS NOT NULL, session_generation
 	FROM users
-	WHERE id = ?`
+	WHERE ` + where
 
-	err := m.DB.QueryRow(statement, id).Scan(&u.ID, &u.Name, &u.Email, &u.Created)
+	err := m.DB.QueryRow(statement, args...).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Disabled, &u.Verified, &u.TwoFactor, &u.SessionGeneration)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -44,48 +93,67 @@ func (m *UserModel) Get(id int) (*User, error) {
 	return u, nil
 }
 
-// Insert creates a new record in the Users table.
-func (m *UserModel) Insert(name, email, password string) error {
+// Insert creates a new record in the Users table and returns its ID. New
+// users start out unverified
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 	// Generate a bcrypt hashed password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 15)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	statement := `INSERT INTO users (name, email, hashed_password, created)
 	VALUES(?, ?, ?, UTC_TIMESTAMP())`
 
-	_, err = m.DB.Exec(statement, name, email, string(hashedPassword))
+	result, err := m.DB.Exec(statement, name, email, string(hashedPassword))
 
 	if err != nil {
-		// Check if this has the type *mysql.MySQLError. If yes, then check the
-		// error code to see if the value is a duplicate value on a unique column.
-		// If the error message contains "users_uc_email", we know that it's this
-		// column that has a duplicate value
-		var mySQLError *mysql.MySQLError
-		if errors.As(err, &mySQLError) {
-			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
-			}
+		if isDuplicateEmail(err) {
+			return 0, ErrDuplicateEmail
 		}
-		return err
+		return 0, err
 	}
-	return nil
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// Check if this has the type *mysql.MySQLError. If yes, then check the
+// error code to see if the value is a duplicate value on a unique column.
+// If the error message contains "users_uc_email", we know that it's this
+// column that has a duplicate value
+func isDuplicateEmail(err error) bool {
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		return mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email")
+	}
+	return false
 }
 
+// Compared against when there's no user with the email address, so logging in
+// takes as long as for one who has an account. Same cost as in Insert
+var dummyHashedPassword = []byte("$2a$15$OmBE1CKqsjexWfe/CsbsxuP0z8bDKx/kr1zqu/mIze2G4rLvQVQGu")
+
 // Authenticate checks if a user exists with this email/password combo and returns
-// the user ID if they do
+// the user ID if they do. Returns ErrDisabledUser or ErrUnverifiedUser if the
+// password is right but the user has been disabled or hasn't been verified yet
 func (m *UserModel) Authenticate(email, password string) (int, error) {
 	// Retrieve the user id and hashed password for this email address
-	// Return an invalid credentials error if no rows containing the email are found
+	// Return an invalid credentials error if no rows containing the email are
+	// found, after the same bcrypt work a real password check does
 	var id int
 	var hashedPassword []byte
+	var disabled, verified bool
 
-	statement := "SELECT id, hashed_password FROM users WHERE email = ?"
+	statement := "SELECT id, hashed_password, disabled, verified FROM users WHERE email = ?"
 
-	err := m.DB.QueryRow(statement, email).Scan(&id, &hashedPassword)
+	err := m.DB.QueryRow(statement, email).Scan(&id, &hashedPassword, &disabled, &verified)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
+			bcrypt.CompareHashAndPassword(dummyHashedPassword, []byte(password))
 			return 0, ErrInvalidCredentials
 		} else {
 			return 0, err
@@ -103,26 +171,59 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 		}
 	}
 
+	// Only tell someone who knows the password that the user is disabled or
+	// unverified
+	if disabled {
+		return 0, ErrDisabledUser
+	}
+	if !verified {
+		return 0, ErrUnverifiedUser
+	}
+
 	// Return id of user if email exists in the db and password matches
 	return id, nil
 }
 
-// Exists checks if a user with this ID exists.
-func (m *UserModel) Exists(id int) (bool, error) {
-	var exists bool
-	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
+// LoggedIn checks if a user with this ID exists, hasn't been disabled and
+// hasn't been logged out everywhere since a session logged in under this
+// generation. Disabling a user also logs them out everywhere
+func (m *UserModel) LoggedIn(id, generation int) (bool, error) {
+	var loggedIn bool
+	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ? AND NOT disabled AND session_generation = ?)"
 
-	err := m.DB.QueryRow(stmt, id).Scan(&exists)
-	return exists, err
+	err := m.DB.QueryRow(stmt, id, generation).Scan(&loggedIn)
+	return loggedIn, err
 }
 
-func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string) error {
+// LogOutEverywhere ends every session the user is logged in with, or waiting
+// for their two-factor code in, by moving them on to a new session generation.
+// Returns ErrNoRecord if there's no user with this ID
+func (m *UserModel) LogOutEverywhere(id int) error {
+	statement := "UPDATE users SET session_generation = session_generation + 1 WHERE id = ?"
+	result, err := m.DB.Exec(statement, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// CheckPassword returns ErrInvalidCredentials if password isn't the user's
+// password. Used to confirm it's really the user before sensitive changes
+func (m *UserModel) CheckPassword(id int, password string) error {
 	hashedPassword, err := m.GetHashedPassword(id)
 	if err != nil {
 		return err
 	}
 
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(currentPassword))
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
 	if err != nil {
 		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
 			return ErrInvalidCredentials
@@ -130,12 +231,6 @@ func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string)
 			return err
 		}
 	}
-
-	err = m.UpdateHashedPassword(id, newPassword)
-	if err != nil {
-		return err
-	}
-
 	return nil
 }
 
@@ -168,3 +263,73 @@ func (m *UserModel) UpdateHashedPassword(id int, password string) error {
 	_, err = m.DB.Exec(statement, string(hashedPassword), id)
 	return err
 }
+
+// Verify marks the user as verified. The email address is the one the
+// verification link was sent to, so a link stops working if the address is
+// changed. Returns ErrNoRecord if there's no such user
+func (m *UserModel) Verify(id int, email string) error {
+	statement := "UPDATE users SET verified = TRUE WHERE id = ? AND email = ?"
+	result, err := m.DB.Exec(statement, id, email)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	// Nothing changed either because the user was already verified or because
+	// there's no such user
+	u, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	if u.Email != email {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// SetDisabled disables or re-enables a user. Returns ErrNoRecord if there's no
+// user with this ID
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	statement := "UPDATE users SET disabled = ? WHERE id = ?"
+	result, err := m.DB.Exec(statement, disabled, id)
+	if err != nil {
+		return err
+	}
+
+	// MySQL counts matched rows as affected only with clientFoundRows, so
+	// check whether the user exists when nothing changed
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		_, err = m.Get(id)
+		return err
+	}
+	return nil
+}
+
+// Numbers of users, for the admin tool
+type UserStats struct {
+	Total    int
+	Disabled int
+}
+
+// Stats counts the users
+func (m *UserModel) Stats() (*UserStats, error) {
+	stats := &UserStats{}
+
+	statement := "SELECT COUNT(*), COALESCE(SUM(disabled), 0) FROM users"
+	err := m.DB.QueryRow(statement).Scan(&stats.Total, &stats.Disabled)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
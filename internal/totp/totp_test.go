@@ -0,0 +1,104 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The secret used by the test vectors of RFC 4226 and RFC 6238
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// RFC 4226 appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for step, code := range want {
+		got := Code(rfcSecret, int64(step))
+		if got != code {
+			t.Errorf("step %d: got %q, want %q", step, got, code)
+		}
+	}
+}
+
+func TestCodeAtTime(t *testing.T) {
+	// RFC 6238 appendix B for SHA1, cut down to six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			got := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "Current code", code: Code(rfcSecret, step), wantStep: step, wantOK: true},
+		{name: "Previous code", code: Code(rfcSecret, step-1), wantStep: step - 1, wantOK: true},
+		{name: "Next code", code: Code(rfcSecret, step+1), wantStep: step + 1, wantOK: true},
+		{name: "With spaces", code: Code(rfcSecret, step)[:3] + " " + Code(rfcSecret, step)[3:], wantStep: step, wantOK: true},
+		{name: "Too old", code: Code(rfcSecret, step-2), wantOK: false},
+		{name: "Too new", code: Code(rfcSecret, step+2), wantOK: false},
+		{name: "Too short", code: Code(rfcSecret, step)[:5], wantOK: false},
+		{name: "Too long", code: Code(rfcSecret, step) + "0", wantOK: false},
+		{name: "Empty", code: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := Validate(rfcSecret, tt.code, now)
+			if gotOK != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("got (%d, %t), want (%d, %t)", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSecretEncoding(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 20 {
+		t.Errorf("got a %d byte secret, want 20", len(secret))
+	}
+
+	encoded := EncodeSecret(rfcSecret)
+	if encoded != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("got %q, want %q", encoded, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	}
+	decoded, err := DecodeSecret(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != string(rfcSecret) {
+		t.Errorf("got %q back, want %q", decoded, rfcSecret)
+	}
+
+	uri := URI("Snippetbox", "alice@example.com", rfcSecret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Snippetbox:alice@example.com?") || !strings.Contains(uri, "secret="+encoded) {
+		t.Errorf("got URI %q", uri)
+	}
+}
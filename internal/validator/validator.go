@@ -65,9 +65,48 @@ func PermittedInt(value int, permittedValues ...int) bool {
 	return false
 }
 
+// Between() returns true if a value is within the range min to max, inclusive.
+func Between(value, min, max int) bool {
+	return value >= min && value <= max
+}
+
+// PermittedValue() returns true if a value is in a list of permitted values.
+func PermittedValue[T comparable](value T, permittedValues ...T) bool {
+	for i := range permittedValues {
+		if value == permittedValues[i] {
+			return true
+		}
+	}
+	return false
+}
+
 // Regex to check if an email address is valid
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// Regex to check if a tag is valid. Tags are lowercase and can't contain
+// characters that have a meaning in URLs, since they are used in /tag/:name
+var TagRX = regexp.MustCompile(`^[a-z0-9][a-z0-9+._-]{0,29}$`)
+
+// Regex to check if a file name is valid. Names can't contain path separators
+// and can only start with a dot when something follows it, so . and .. aren't
+// valid names
+var FilenameRX = regexp.MustCompile(`^\.?[a-zA-Z0-9_+-][a-zA-Z0-9 ._+-]*$`)
+
+// MaxItems() returns true if a list contains no more than n values.
+func MaxItems(values []string, n int) bool {
+	return len(values) <= n
+}
+
+// AllMatch() returns true if every value in a list matches the regex pattern.
+func AllMatch(values []string, rx *regexp.Regexp) bool {
+	for _, value := range values {
+		if !rx.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
 // Min chars returns true if a value contains at least n characters
 func MinChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) >= n
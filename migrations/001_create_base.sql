@@ -0,0 +1,38 @@
+-- Schema migrations for snippetbox, applied in order of their numbers to the
+-- database the web server's -dsn points at, e.g.
+--
+--	for f in migrations/*.sql; do mysql -u root snippetbox < "$f"; done
+--
+-- The database itself is created with utf8mb4, so titles and content can hold
+-- any character:
+--
+--	CREATE DATABASE snippetbox CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
+--
+-- This first migration is the schema the app started out with.
+
+CREATE TABLE snippets (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL
+);
+CREATE INDEX idx_snippets_created ON snippets(created);
+
+-- Used by the scs mysqlstore session store
+CREATE TABLE sessions (
+    token CHAR(43) PRIMARY KEY,
+    data BLOB NOT NULL,
+    expiry TIMESTAMP(6) NOT NULL
+);
+CREATE INDEX sessions_expiry_idx ON sessions (expiry);
+
+-- isDuplicateEmail looks for the users_uc_email constraint by name
+CREATE TABLE users (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    hashed_password CHAR(60) NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT users_uc_email UNIQUE (email)
+);
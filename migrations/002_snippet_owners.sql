@@ -0,0 +1,4 @@
+-- Snippets remember the user who created them. Snippets from before this have
+-- no owner and are shown as anonymous
+ALTER TABLE snippets ADD COLUMN user_id INTEGER NULL,
+    ADD CONSTRAINT snippets_fk_user FOREIGN KEY (user_id) REFERENCES users(id);
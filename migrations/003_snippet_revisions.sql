@@ -0,0 +1,14 @@
+-- Every saved version of a snippet. The unique key stops two saves from
+-- getting the same version number, and lists a snippet's history in order
+CREATE TABLE snippet_revisions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    user_id INTEGER NULL,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT snippet_revisions_uc_version UNIQUE (snippet_id, version),
+    CONSTRAINT snippet_revisions_fk_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id),
+    CONSTRAINT snippet_revisions_fk_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
@@ -0,0 +1,4 @@
+-- Lets listings sorted by expiry page through snippets by (expires, id), like
+-- idx_snippets_created does for the created sort. InnoDB appends the primary
+-- key to secondary indexes, so the id doesn't need to be listed
+CREATE INDEX idx_snippets_expires ON snippets(expires);
@@ -0,0 +1,3 @@
+-- Search matches titles and content with MATCH ... AGAINST, which needs a
+-- FULLTEXT index on exactly these columns
+ALTER TABLE snippets ADD FULLTEXT INDEX snippets_ft_title_content (title, content);
@@ -0,0 +1,17 @@
+-- Tag names are unique, so INSERT IGNORE can add a tag that may exist already.
+-- The primary key of snippet_tags loads a snippet's tags, and the tag_id index
+-- finds the snippets with a tag for its listing page
+CREATE TABLE tags (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(30) NOT NULL,
+    CONSTRAINT tags_uc_name UNIQUE (name)
+);
+
+CREATE TABLE snippet_tags (
+    snippet_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY (snippet_id, tag_id),
+    CONSTRAINT snippet_tags_fk_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id),
+    CONSTRAINT snippet_tags_fk_tag FOREIGN KEY (tag_id) REFERENCES tags(id)
+);
+CREATE INDEX idx_snippet_tags_tag ON snippet_tags(tag_id);
@@ -0,0 +1,3 @@
+-- The language a snippet is highlighted as. Existing snippets get the empty
+-- language, which is shown as plain text
+ALTER TABLE snippets ADD COLUMN language VARCHAR(50) NOT NULL DEFAULT '';
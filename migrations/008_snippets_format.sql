@@ -0,0 +1,2 @@
+-- Whether a snippet is code or markdown. Existing snippets stay code
+ALTER TABLE snippets ADD COLUMN format VARCHAR(10) NOT NULL DEFAULT 'code';
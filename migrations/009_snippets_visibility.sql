@@ -0,0 +1,6 @@
+-- Who can see a snippet, and the random slug that unlisted and private
+-- snippets are reached by. Existing snippets stay public and get a slug the
+-- first time they're edited
+ALTER TABLE snippets ADD COLUMN visibility VARCHAR(10) NOT NULL DEFAULT 'public',
+    ADD COLUMN slug CHAR(22) NULL,
+    ADD CONSTRAINT snippets_uc_slug UNIQUE (slug);
@@ -0,0 +1,4 @@
+-- How many times a snippet may be viewed, NULL for any number of times, and
+-- how many times it has been
+ALTER TABLE snippets ADD COLUMN max_views INTEGER NULL,
+    ADD COLUMN views INTEGER NOT NULL DEFAULT 0;
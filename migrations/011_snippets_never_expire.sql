@@ -0,0 +1,2 @@
+-- NULL expires means the snippet never expires
+ALTER TABLE snippets MODIFY expires DATETIME NULL;
@@ -0,0 +1,5 @@
+-- The snippet a fork was copied from. There's no foreign key, deleting an
+-- original clears forked_from on its forks in the same transaction instead.
+-- The index finds an original's forks
+ALTER TABLE snippets ADD COLUMN forked_from INTEGER NULL;
+CREATE INDEX idx_snippets_forked_from ON snippets(forked_from);
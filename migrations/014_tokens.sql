@@ -0,0 +1,15 @@
+-- Personal API tokens. Only the SHA-256 hash of a token is stored, and tokens
+-- are looked up by it. scopes is a comma separated list, expires is NULL for
+-- tokens that never expire
+CREATE TABLE tokens (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    hash BINARY(32) NOT NULL,
+    scopes VARCHAR(20) NOT NULL,
+    expires DATETIME NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME NULL,
+    CONSTRAINT tokens_uc_hash UNIQUE (hash),
+    CONSTRAINT fk_tokens_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
@@ -0,0 +1,4 @@
+-- Users can't log in until they've verified their email address. Users from
+-- before verification count as verified
+ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE users SET verified = TRUE;
@@ -0,0 +1,9 @@
+-- Forgotten password tokens. Only their SHA-256 hash is stored, and setting a
+-- new password deletes all of the user's tokens
+CREATE TABLE password_resets (
+    hash BINARY(32) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires DATETIME NOT NULL,
+    CONSTRAINT fk_password_resets_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX idx_password_resets_expires ON password_resets(expires);
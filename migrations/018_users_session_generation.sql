@@ -0,0 +1,4 @@
+-- Goes up every time a user is logged out everywhere. Sessions remember the
+-- generation they logged in under and stop counting once it changes. Sessions
+-- from before this have no generation stored and read as 0, so they carry on
+ALTER TABLE users ADD COLUMN session_generation INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+-- Users with a TOTP secret need a code from their authenticator app to log
+-- in. totp_last_step is the time step of the last code accepted, so a code
+-- can't be used twice. Recovery codes are stored as SHA-256 hashes
+ALTER TABLE users
+    ADD COLUMN totp_secret VARBINARY(20) NULL,
+    ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+    user_id INTEGER NOT NULL,
+    hash BINARY(32) NOT NULL,
+    PRIMARY KEY (user_id, hash),
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
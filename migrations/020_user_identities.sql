@@ -0,0 +1,12 @@
+-- Accounts at OpenID Connect identity providers that users log in with. The
+-- primary key allows each identity to be linked only once. LinkIdentity and
+-- InsertWithIdentity rely on its duplicate key error when two first logins
+-- race, without it one identity could end up with two users
+CREATE TABLE user_identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (issuer, subject),
+    CONSTRAINT fk_user_identities_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
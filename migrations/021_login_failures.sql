@@ -0,0 +1,14 @@
+-- Failed logins, counted for each email address and each IP address or IPv6
+-- network. LoginFailureModel.Attempt upserts with ON DUPLICATE KEY UPDATE and
+-- locks the row with FOR UPDATE, which both need the primary key on
+-- (kind, name). Without it every failure would get a row of its own and
+-- nobody would ever be blocked. The expires index is for the reaper
+CREATE TABLE login_failures (
+    kind VARCHAR(8) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    failures INTEGER NOT NULL,
+    blocked_until DATETIME(6) NULL,
+    expires DATETIME(6) NOT NULL,
+    PRIMARY KEY (kind, name),
+    INDEX idx_login_failures_expires (expires)
+);